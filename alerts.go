@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// AlertSink receives critical entries in addition to the normal broadcast.
+type AlertSink interface {
+	Name() string
+	Send(u Update) error
+}
+
+// WebhookAlertSink posts alerts as JSON to a URL. The payload carries a
+// "text" field so it can be pointed at chat incoming webhooks directly.
+type WebhookAlertSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookAlertSink(url string) *WebhookAlertSink {
+	return &WebhookAlertSink{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *WebhookAlertSink) Name() string {
+	return "webhook " + s.URL
+}
+
+func (s *WebhookAlertSink) Send(u Update) error {
+	body, err := json.Marshal(struct {
+		Text  string `json:"text"`
+		Entry Update `json:"entry"`
+	}{
+		Text:  alertSubject(u),
+		Entry: u,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.Client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// EmailAlertSink mails alerts to a fixed list of recipients.
+type EmailAlertSink struct {
+	Mailer *Mailer
+	To     []string
+}
+
+func (s *EmailAlertSink) Name() string {
+	return "email " + strings.Join(s.To, ",")
+}
+
+func (s *EmailAlertSink) Send(u Update) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s [%s] %s\n\n", u.Timestamp, u.Username, u.Severity)
+	body.WriteString(u.Message + "\n")
+	if len(u.Tags) > 0 {
+		fmt.Fprintf(&body, "\ntags: %s\n", strings.Join(u.Tags, ", "))
+	}
+	return s.Mailer.Send(s.To, alertSubject(u), body.String())
+}
+
+// alertSubject is a one-line summary of an alert. It is used as a mail
+// subject, so it must not contain line breaks or other control characters.
+func alertSubject(u Update) string {
+	msg := u.Message
+	if i := strings.IndexAny(msg, "\r\n"); i >= 0 {
+		msg = msg[:i]
+	}
+
+	subject := fmt.Sprintf("[HAL %s] %s: %s", u.Severity, u.Username, msg)
+	if u.EscalationLevel > 0 {
+		subject = fmt.Sprintf("[HAL %s ESCALATION %d] %s: %s", u.Severity, u.EscalationLevel, u.Username, msg)
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, subject)
+}
+
+// routeAlert sends an entry to every configured alert sink, logging failures.
+func (s *Server) routeAlert(u Update) {
+	for _, sink := range s.cfg.AlertSinks {
+		if err := sink.Send(u); err != nil {
+			log.Printf("alert: %s: entry %d: %v", sink.Name(), u.ID, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlertSubjectIsOneLine(t *testing.T) {
+	tests := []struct {
+		name string
+		u    Update
+		want string
+	}{
+		{
+			name: "bare CR",
+			u:    Update{Severity: SeverityCrit, Username: "DAVE", Message: "AE-35 failure\rBcc: everyone@discovery.one"},
+			want: "[HAL CRIT] DAVE: AE-35 failure",
+		},
+		{
+			name: "CRLF",
+			u:    Update{Severity: SeverityCrit, Username: "DAVE", Message: "AE-35 failure\r\nBcc: everyone@discovery.one"},
+			want: "[HAL CRIT] DAVE: AE-35 failure",
+		},
+		{
+			name: "control characters in username",
+			u:    Update{Severity: SeverityCrit, Username: "DAVE\r\nBcc: x", Message: "AE-35 failure", EscalationLevel: 2},
+			want: "[HAL CRIT ESCALATION 2] DAVEBcc: x: AE-35 failure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := alertSubject(tt.u)
+			if strings.ContainsAny(got, "\r\n") {
+				t.Fatalf("subject %q contains a line break", got)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
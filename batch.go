@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxBatchSize is the number of items accepted by a single batch request.
+	maxBatchSize = 1000
+
+	// maxBatchLineSize limits a single NDJSON line.
+	maxBatchLineSize = 1 << 20
+
+	// maxBatchBodySize limits the body of a batch request.
+	maxBatchBodySize = 32 << 20
+)
+
+// BatchItem is one entry of a batch request. Timestamp is optional and lets
+// scripts backfill entries; it must not be in the future.
+type BatchItem struct {
+	UpdateRequest
+	Timestamp string `json:"timestamp"`
+}
+
+// BatchResult is the outcome for one item of a batch request.
+type BatchResult struct {
+	Index  int     `json:"index"`
+	Status string  `json:"status"`
+	Error  string  `json:"error,omitempty"`
+	Entry  *Update `json:"entry,omitempty"`
+}
+
+// BatchResponse summarises a batch request.
+type BatchResponse struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Results  []BatchResult `json:"results"`
+}
+
+// Batch item statuses.
+const (
+	BatchItemAccepted = "accepted"
+	BatchItemRejected = "rejected"
+)
+
+// readBatch reads the raw items of a batch request, either as a JSON array
+// or as newline-delimited JSON. NDJSON lines are returned individually so a
+// malformed line only rejects that item. Reading stops once more than limit
+// items have been seen so the caller can reject oversized requests.
+func readBatch(r io.Reader, limit int) ([]json.RawMessage, error) {
+	br := bufio.NewReader(r)
+
+	// Skip leading whitespace to see whether this is an array.
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			break
+		}
+		br.ReadByte() // nolint:errcheck
+	}
+
+	if b, _ := br.Peek(1); b[0] == '[' {
+		var items []json.RawMessage
+		if err := json.NewDecoder(br).Decode(&items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	var items []json.RawMessage
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		items = append(items, json.RawMessage(bytes.Clone(line)))
+		if len(items) > limit {
+			break
+		}
+	}
+	return items, scanner.Err()
+}
+
+// batchTimestamp validates an optional backfill timestamp, defaulting to now.
+func batchTimestamp(v string, now time.Time) (string, error) {
+	if v == "" {
+		return now.Format(time.RFC3339), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return "", &BadRequestError{Msg: fmt.Sprintf("invalid timestamp %q", v)}
+	}
+	if t.After(now) {
+		return "", &BadRequestError{Msg: "timestamp is in the future"}
+	}
+	return t.In(time.Local).Format(time.RFC3339), nil
+}
+
+// handleBatchPost accepts many updates in one request. Every item is
+// validated on its own; the accepted ones are inserted in a single
+// transaction and then broadcast.
+func (s *Server) handleBatchPost(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	raw, err := readBatch(http.MaxBytesReader(w, r.Body, maxBatchBodySize), maxBatchSize)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if len(raw) == 0 {
+		http.Error(w, "empty batch", http.StatusBadRequest)
+		return
+	}
+	if len(raw) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("batch too large (max %d items)", maxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	now := time.Now()
+	resp := BatchResponse{Results: make([]BatchResult, len(raw))}
+	var accepted []*Update
+
+	for i, item := range raw {
+		resp.Results[i] = BatchResult{Index: i, Status: BatchItemRejected}
+
+		var in BatchItem
+		if err := json.Unmarshal(item, &in); err != nil {
+			resp.Results[i].Error = "invalid JSON"
+			continue
+		}
+
+		u, err := s.updateFromRequest(user, in.UpdateRequest)
+		if err == nil {
+			u.Timestamp, err = batchTimestamp(in.Timestamp, now)
+		}
+		if err != nil {
+			if !isBadRequest(err) {
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			resp.Results[i].Error = err.Error()
+			continue
+		}
+
+		resp.Results[i].Status = BatchItemAccepted
+		resp.Results[i].Entry = &u
+		accepted = append(accepted, &u)
+	}
+
+	if len(accepted) > 0 {
+		tx, err := s.db.Begin()
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback() // nolint:errcheck
+
+		for _, u := range accepted {
+			if err := insertUpdateTx(tx, u, user.ID); err != nil {
+				http.Error(w, "failed to insert updates", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "failed to insert updates", http.StatusInternalServerError)
+			return
+		}
+
+		published := make([]Update, len(accepted))
+		for i, u := range accepted {
+			published[i] = *u
+		}
+		s.publishAll(published)
+	}
+
+	resp.Accepted = len(accepted)
+	resp.Rejected = len(raw) - len(accepted)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp) // nolint:errcheck
+}
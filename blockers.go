@@ -0,0 +1,166 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlockerTag marks an entry as a blocker that stays open until resolved.
+const BlockerTag = "BLOCKER"
+
+// Blocker statuses stored in log_entries.status.
+const (
+	BlockerStatusOpen     = "open"
+	BlockerStatusResolved = "resolved"
+)
+
+// EventResolved is the stream event sent when a blocker is resolved.
+const EventResolved = "resolved"
+
+// Resolution records how and by whom a blocker was resolved.
+type Resolution struct {
+	Note       string `json:"note,omitempty"`
+	ResolvedBy string `json:"resolved_by,omitempty"`
+	ResolvedAt string `json:"resolved_at"`
+}
+
+// Blocker is a blocker entry along with how long it has been (or was) open.
+type Blocker struct {
+	Update
+	AgeSeconds int64  `json:"age_seconds"`
+	Age        string `json:"age"`
+}
+
+// isBlocker reports whether tags contain the blocker tag.
+func isBlocker(tags []string) bool {
+	for _, tag := range tags {
+		if strings.EqualFold(tag, BlockerTag) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockerAge is the time a blocker has been open, or was open before it was resolved.
+func blockerAge(u Update, now time.Time) time.Duration {
+	opened, err := time.Parse(time.RFC3339, u.Timestamp)
+	if err != nil {
+		return 0
+	}
+	if u.Resolution != nil {
+		if resolved, err := time.Parse(time.RFC3339, u.Resolution.ResolvedAt); err == nil {
+			now = resolved
+		}
+	}
+	return now.Sub(opened).Truncate(time.Second)
+}
+
+// newBlocker wraps a blocker entry with its age.
+func newBlocker(u Update, now time.Time) Blocker {
+	age := blockerAge(u, now)
+	return Blocker{
+		Update:     u,
+		AgeSeconds: int64(age.Seconds()),
+		Age:        age.String(),
+	}
+}
+
+// handleBlockers lists blockers across the crew, oldest first.
+// It returns open blockers unless ?status=resolved is given, and ?user= narrows
+// the list to a single crew member.
+func (s *Server) handleBlockers(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = BlockerStatusOpen
+	}
+	if status != BlockerStatusOpen && status != BlockerStatusResolved {
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	username := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("user")))
+	minSeverity, err := parseMinSeverity(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := s.db.Query(SelectBlockersQuery, status)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close() // nolint:errcheck
+
+	now := time.Now()
+	list := []Blocker{}
+	for rows.Next() {
+		u, err := scanUpdate(rows)
+		if err != nil {
+			continue
+		}
+		if username != "" && u.Username != username {
+			continue
+		}
+		if severityRank(u.Severity) < minSeverity {
+			continue
+		}
+
+		list = append(list, newBlocker(u, now))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list) // nolint:errcheck
+}
+
+func (s *Server) handleResolveBlocker(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var in struct {
+		Resolution string `json:"resolution"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	resolvedAt := time.Now().Format(time.RFC3339)
+	res, err := s.db.Exec(ResolveBlockerQuery, strings.TrimSpace(in.Resolution), resolvedAt, user.ID, id)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	u, err := s.getUpdate(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "entry not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "entry is not an open blocker", http.StatusConflict)
+		return
+	}
+
+	u.Event = EventResolved
+	s.publish(u)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u) // nolint:errcheck
+}
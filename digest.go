@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// digestInterval is how often the scheduler looks for digests that are due.
+const digestInterval = time.Minute
+
+// DigestSubscription is a user's preference for receiving digests by email.
+// LastPeriod is the first day of the last period that was mailed.
+type DigestSubscription struct {
+	UserID           int64  `json:"-"`
+	Username         string `json:"username"`
+	Email            string `json:"email"`
+	Frequency        string `json:"frequency"`
+	UnsubscribeToken string `json:"-"`
+	LastPeriod       string `json:"last_period,omitempty"`
+	CreatedAt        string `json:"created_at"`
+}
+
+func scanDigestSubscription(rows rowScanner) (DigestSubscription, error) {
+	var sub DigestSubscription
+	err := rows.Scan(&sub.UserID, &sub.Username, &sub.Email, &sub.Frequency,
+		&sub.UnsubscribeToken, &sub.LastPeriod, &sub.CreatedAt)
+	return sub, err
+}
+
+// digestPeriod returns the most recent complete period whose digest is due
+// at now. Digests for a day or week go out at hour o'clock the following day
+// (for weeks, the following Monday).
+func digestPeriod(frequency string, now time.Time, hour int) (time.Time, time.Time) {
+	ref := now.Add(-time.Duration(hour) * time.Hour)
+	days := 1
+	if frequency == ReportWeekly {
+		days = 7
+	}
+	return reportRange(frequency, ref.AddDate(0, 0, -days))
+}
+
+// runDigests periodically mails the digests that have become due.
+func (s *Server) runDigests() {
+	ticker := time.NewTicker(digestInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.sendDueDigests(time.Now()); err != nil {
+			log.Printf("digest: %v", err)
+		}
+	}
+}
+
+// sendDueDigests mails every subscriber whose latest due period has not been
+// sent yet. Reports are built once per period and shared between subscribers.
+func (s *Server) sendDueDigests(now time.Time) error {
+	rows, err := s.db.Query(SelectDigestSubscriptionsQuery)
+	if err != nil {
+		return err
+	}
+
+	var due []DigestSubscription
+	for rows.Next() {
+		sub, err := scanDigestSubscription(rows)
+		if err != nil {
+			continue
+		}
+		from, _ := digestPeriod(sub.Frequency, now, s.cfg.DigestHour)
+		if sub.LastPeriod < from.Format(dateLayout) {
+			due = append(due, sub)
+		}
+	}
+	rows.Close() // nolint:errcheck
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	reports := map[string]*Report{}
+	for _, sub := range due {
+		from, to := digestPeriod(sub.Frequency, now, s.cfg.DigestHour)
+
+		key := sub.Frequency + from.Format(dateLayout)
+		rep, ok := reports[key]
+		if !ok {
+			rep, err = s.buildReport(sub.Frequency, EntryFilter{From: from, To: to})
+			if err != nil {
+				return err
+			}
+			reports[key] = rep
+		}
+
+		if err := s.sendDigest(sub, rep, s.cfg.PublicURL); err != nil {
+			log.Printf("digest: %s: %v", sub.Username, err)
+			continue
+		}
+		if _, err := s.db.Exec(UpdateDigestLastPeriodQuery, rep.From, sub.UserID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendDigest mails a report to a subscriber with plain-text and HTML bodies
+// and a link to unsubscribe.
+func (s *Server) sendDigest(sub DigestSubscription, rep *Report, baseURL string) error {
+	unsubscribe := strings.TrimRight(baseURL, "/") + "/digest/unsubscribe/" + sub.UnsubscribeToken
+
+	text := rep.Markdown() + "\n-- \nYou receive this " + rep.Period + " digest as " + sub.Username +
+		".\nUnsubscribe: " + unsubscribe + "\n"
+
+	var html bytes.Buffer
+	err := s.templates.ExecuteTemplate(&html, "digest_email.html", struct {
+		Report         *Report
+		Subscription   DigestSubscription
+		UnsubscribeURL string
+	}{rep, sub, unsubscribe})
+	if err != nil {
+		return err
+	}
+
+	return s.cfg.Mailer.SendMessage(&MailMessage{
+		To:      []string{sub.Email},
+		Subject: rep.Title(),
+		Text:    text,
+		HTML:    html.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+}
+
+// requireDigests writes an error response and returns false when the server
+// was started without email digests.
+func (s *Server) requireDigests(w http.ResponseWriter) bool {
+	if !s.cfg.Digests {
+		http.Error(w, "email digests disabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// getDigestSubscription returns the subscription of a user, if any.
+func (s *Server) getDigestSubscription(userID int64) (DigestSubscription, error) {
+	return scanDigestSubscription(s.db.QueryRow(SelectDigestSubscriptionQuery, userID))
+}
+
+func (s *Server) handleDigestSubscription(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	sub, err := s.getDigestSubscription(user.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "not subscribed", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sub) // nolint:errcheck
+}
+
+// handleSubscribeDigest creates or updates the caller's subscription. The
+// first digest is the one for the next period that becomes due.
+func (s *Server) handleSubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	if !s.requireDigests(w) {
+		return
+	}
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var in struct {
+		Email     string `json:"email"`
+		Frequency string `json:"frequency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	addr, err := mail.ParseAddress(in.Email)
+	if err != nil {
+		http.Error(w, "valid email required", http.StatusBadRequest)
+		return
+	}
+	if in.Frequency == "" {
+		in.Frequency = ReportDaily
+	}
+	frequency, err := parseReportPeriod(in.Frequency)
+	if err != nil {
+		http.Error(w, "frequency must be daily or weekly", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	from, _ := digestPeriod(frequency, now, s.cfg.DigestHour)
+	_, err = s.db.Exec(UpsertDigestSubscriptionQuery, user.ID, addr.Address, frequency,
+		generateToken(), from.Format(dateLayout), now.Format(time.RFC3339))
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	sub, err := s.getDigestSubscription(user.ID)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sub) // nolint:errcheck
+}
+
+func (s *Server) handleDeleteDigestSubscription(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec(DeleteDigestSubscriptionQuery, user.ID)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "not subscribed", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleUnsubscribePage serves the unsubscribe link from digest emails. It
+// only asks for confirmation, so mail scanners that follow links don't
+// unsubscribe anyone.
+func (s *Server) handleUnsubscribePage(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	sub, err := scanDigestSubscription(s.db.QueryRow(SelectDigestSubscriptionByTokenQuery, token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "unknown or expired unsubscribe link", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.renderPage(w, "unsubscribe.html", struct {
+		Title        string
+		Subscription DigestSubscription
+		Token        string
+	}{"HAL 9000 // unsubscribe", sub, token})
+}
+
+// handleUnsubscribeDigest removes the subscription behind an unsubscribe
+// token. It is posted by the confirmation page and by mail clients that
+// support RFC 8058 one-click unsubscribe.
+func (s *Server) handleUnsubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	res, err := s.db.Exec(UnsubscribeDigestQuery, r.PathValue("token"))
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "unknown or expired unsubscribe link", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "You have been unsubscribed from HAL digests.")
+}
+
+// handleSendDigest mails the caller's digest right away, for the period
+// containing ?date= or, by default, the latest one due.
+func (s *Server) handleSendDigest(w http.ResponseWriter, r *http.Request) {
+	if !s.requireDigests(w) {
+		return
+	}
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	sub, err := s.getDigestSubscription(user.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "not subscribed", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	from, to := digestPeriod(sub.Frequency, time.Now(), s.cfg.DigestHour)
+	if v := r.URL.Query().Get("date"); v != "" {
+		day, err := time.ParseInLocation(dateLayout, v, time.Local)
+		if err != nil {
+			http.Error(w, "invalid date", http.StatusBadRequest)
+			return
+		}
+		from, to = reportRange(sub.Frequency, day)
+	}
+
+	rep, err := s.buildReport(sub.Frequency, EntryFilter{From: from, To: to})
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.sendDigest(sub, rep, s.baseURL(r)); err != nil {
+		log.Printf("digest: %s: %v", sub.Username, err)
+		http.Error(w, "failed to send digest", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream events sent when a CRIT entry is acknowledged or escalated.
+const (
+	EventAcknowledged = "acknowledged"
+	EventEscalated    = "escalated"
+)
+
+// Acknowledgement records who acknowledged an entry and when.
+type Acknowledgement struct {
+	AckedBy string `json:"acked_by,omitempty"`
+	AckedAt string `json:"acked_at"`
+}
+
+// Escalation is one step in the escalation chain of an unacknowledged CRIT entry.
+type Escalation struct {
+	Level     int      `json:"level"`
+	Notified  []string `json:"notified"`
+	Timestamp string   `json:"timestamp"`
+}
+
+// escalationInterval is how often the escalation engine looks for overdue
+// entries: a quarter of the window, between one second and one minute.
+func escalationInterval(window time.Duration) time.Duration {
+	return min(max(window/4, time.Second), time.Minute)
+}
+
+// runEscalations periodically escalates CRIT entries that have gone
+// unacknowledged for longer than the escalation window.
+func (s *Server) runEscalations() {
+	ticker := time.NewTicker(escalationInterval(s.cfg.EscalationWindow))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.escalateOverdue(time.Now()); err != nil {
+			log.Printf("escalation: %v", err)
+		}
+	}
+}
+
+// escalateOverdue escalates every unacknowledged CRIT entry whose last
+// escalation (or posting) is older than the window.
+func (s *Server) escalateOverdue(now time.Time) error {
+	rows, err := s.db.Query(SelectUnackedCritEntriesQuery, s.cfg.EscalationMax)
+	if err != nil {
+		return err
+	}
+
+	type overdue struct {
+		id    int64
+		level int
+	}
+	var due []overdue
+
+	for rows.Next() {
+		var (
+			id          int64
+			ts          string
+			escalatedAt string
+			level       int
+		)
+		if err := rows.Scan(&id, &ts, &escalatedAt, &level); err != nil {
+			rows.Close() // nolint:errcheck
+			return err
+		}
+
+		since := ts
+		if escalatedAt != "" {
+			since = escalatedAt
+		}
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil || now.Sub(t) < s.cfg.EscalationWindow {
+			continue
+		}
+		due = append(due, overdue{id: id, level: level})
+	}
+	rows.Close() // nolint:errcheck
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		if err := s.escalate(d.id, d.level+1, now); err != nil {
+			log.Printf("escalation: entry %d: %v", d.id, err)
+		}
+	}
+	return nil
+}
+
+// escalate raises an entry to the given level, notifies the escalation
+// sinks, records the step and re-broadcasts the entry.
+func (s *Server) escalate(id int64, level int, now time.Time) error {
+	ts := now.Format(time.RFC3339)
+
+	res, err := s.db.Exec(EscalateEntryQuery, level, ts, id, level-1)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Acknowledged or escalated in the meantime.
+		return nil
+	}
+
+	u, err := s.getUpdate(id)
+	if err != nil {
+		return err
+	}
+
+	notified := []string{}
+	for _, sink := range s.cfg.EscalationSinks {
+		if err := sink.Send(u); err != nil {
+			log.Printf("escalation: %s: entry %d: %v", sink.Name(), id, err)
+			continue
+		}
+		notified = append(notified, sink.Name())
+	}
+
+	if _, err := s.db.Exec(InsertEscalationQuery, id, level, strings.Join(notified, "\n"), ts); err != nil {
+		return err
+	}
+
+	if u.Escalations, err = s.getEscalations(id); err != nil {
+		return err
+	}
+	u.Event = EventEscalated
+	s.publish(u)
+	return nil
+}
+
+// getEscalations loads the escalation chain of an entry.
+func (s *Server) getEscalations(id int64) ([]Escalation, error) {
+	rows, err := s.db.Query(SelectEscalationsQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint:errcheck
+
+	var chain []Escalation
+	for rows.Next() {
+		var (
+			e        Escalation
+			notified string
+		)
+		if err := rows.Scan(&e.Level, &notified, &e.Timestamp); err != nil {
+			return nil, err
+		}
+		e.Notified = splitLines(notified)
+		chain = append(chain, e)
+	}
+	return chain, rows.Err()
+}
+
+// splitLines splits a newline-separated column, returning an empty slice for "".
+func splitLines(v string) []string {
+	if v == "" {
+		return []string{}
+	}
+	return strings.Split(v, "\n")
+}
+
+func (s *Server) handleAckEntry(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.db.Exec(AckEntryQuery, time.Now().Format(time.RFC3339), user.ID, id)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	u, err := s.getUpdate(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "entry not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "entry already acknowledged", http.StatusConflict)
+		return
+	}
+
+	if u.Escalations, err = s.getEscalations(id); err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	u.Event = EventAcknowledged
+	s.publish(u)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u) // nolint:errcheck
+}
+
+func (s *Server) handleEscalations(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.getUpdate(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "entry not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	chain, err := s.getEscalations(id)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if chain == nil {
+		chain = []Escalation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chain) // nolint:errcheck
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EntryFilter selects entries by a range of days and, optionally, by user and tag.
+type EntryFilter struct {
+	From time.Time
+	To   time.Time
+	User string
+	Tag  string
+}
+
+// args returns the parameters for queries built on entryRangeFilter.
+func (f EntryFilter) args() []any {
+	return []any{
+		f.From.Format(dateLayout), f.To.Format(dateLayout),
+		f.User, f.User,
+		f.Tag, f.Tag,
+	}
+}
+
+// parseEntryFilter reads the from, to, user and tag query parameters.
+// The range defaults as in parseDateRange and the tag is resolved against
+// the taxonomy so aliases find the canonical tag.
+func (s *Server) parseEntryFilter(r *http.Request, days int) (EntryFilter, error) {
+	from, to, err := parseDateRange(r, days)
+	if err != nil {
+		return EntryFilter{}, err
+	}
+
+	f := EntryFilter{
+		From: from,
+		To:   to,
+		User: strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("user"))),
+	}
+	if v := r.URL.Query().Get("tag"); v != "" {
+		f.Tag = s.canonicalTag(v)
+	}
+	return f, nil
+}
+
+// entryWriter encodes a stream of entries in one export format.
+type entryWriter interface {
+	WriteEntry(u Update) error
+	Close() error
+}
+
+// exportFormat describes one format supported by /export.
+type exportFormat struct {
+	contentType string
+	byDay       bool
+	newWriter   func(w io.Writer) entryWriter
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":    {"text/csv; charset=utf-8", false, newCSVEntryWriter},
+	"json":   {"application/json", false, newJSONEntryWriter},
+	"ndjson": {"application/x-ndjson", false, newNDJSONEntryWriter},
+	"md":     {"text/markdown; charset=utf-8", true, newMarkdownEntryWriter},
+}
+
+// csvExportHeader names the CSV columns. user, message, tags, timestamp and
+// severity match the defaults of the importer.
+var csvExportHeader = []string{"id", "timestamp", "user", "severity", "tags", "message", "kind", "status", "resolution"}
+
+type csvEntryWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVEntryWriter(w io.Writer) entryWriter {
+	return &csvEntryWriter{w: csv.NewWriter(w)}
+}
+
+func (cw *csvEntryWriter) writeHeader() error {
+	if cw.header {
+		return nil
+	}
+	cw.header = true
+	return cw.w.Write(csvExportHeader)
+}
+
+func (cw *csvEntryWriter) WriteEntry(u Update) error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+
+	var resolution string
+	if u.Resolution != nil {
+		resolution = u.Resolution.Note
+	}
+	return cw.w.Write([]string{
+		strconv.FormatInt(u.ID, 10),
+		u.Timestamp,
+		u.Username,
+		u.Severity,
+		strings.Join(u.Tags, ","),
+		u.Message,
+		u.Kind,
+		u.Status,
+		resolution,
+	})
+}
+
+func (cw *csvEntryWriter) Close() error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// jsonEntryWriter writes a JSON array one element at a time.
+type jsonEntryWriter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+func newJSONEntryWriter(w io.Writer) entryWriter {
+	return &jsonEntryWriter{w: w, enc: json.NewEncoder(w)}
+}
+
+func (jw *jsonEntryWriter) WriteEntry(u Update) error {
+	sep := ","
+	if jw.count == 0 {
+		sep = "["
+	}
+	jw.count++
+	if _, err := io.WriteString(jw.w, sep); err != nil {
+		return err
+	}
+	return jw.enc.Encode(u)
+}
+
+func (jw *jsonEntryWriter) Close() error {
+	end := "]\n"
+	if jw.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(jw.w, end)
+	return err
+}
+
+type ndjsonEntryWriter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONEntryWriter(w io.Writer) entryWriter {
+	return &ndjsonEntryWriter{enc: json.NewEncoder(w)}
+}
+
+func (nw *ndjsonEntryWriter) WriteEntry(u Update) error {
+	return nw.enc.Encode(u)
+}
+
+func (nw *ndjsonEntryWriter) Close() error {
+	return nil
+}
+
+// markdownEntryWriter groups entries by day and user, expecting them in
+// that order. The Markdown importer reads the output back with its
+// severities and tags; blocker state is not imported.
+type markdownEntryWriter struct {
+	w    io.Writer
+	day  string
+	user string
+}
+
+func newMarkdownEntryWriter(w io.Writer) entryWriter {
+	return &markdownEntryWriter{w: w}
+}
+
+func (mw *markdownEntryWriter) WriteEntry(u Update) error {
+	var b strings.Builder
+
+	t, err := time.Parse(time.RFC3339, u.Timestamp)
+	if err != nil {
+		return err
+	}
+	t = t.In(time.Local)
+
+	if day := t.Format(dateLayout); day != mw.day {
+		if mw.day != "" {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "## %s\n", day)
+		mw.day, mw.user = day, ""
+	}
+	if u.Username != mw.user {
+		fmt.Fprintf(&b, "\n### %s\n\n", u.Username)
+		mw.user = u.Username
+	}
+
+	b.WriteString("- " + t.Format("15:04") + " ")
+	if u.Severity != "" && u.Severity != SeverityInfo {
+		b.WriteString("**" + u.Severity + "** ")
+	}
+	b.WriteString(strings.ReplaceAll(u.Message, "\n", "\n  "))
+
+	// Tags that aren't already hashtags in the message are appended as hashtags.
+	upper := strings.ToUpper(u.Message)
+	for _, tag := range u.Tags {
+		if !strings.Contains(upper, "#"+tag) {
+			b.WriteString(" #" + tag)
+		}
+	}
+	if u.Resolution != nil {
+		fmt.Fprintf(&b, " _(resolved by %s: %s)_", u.Resolution.ResolvedBy, u.Resolution.Note)
+	}
+	b.WriteString("\n")
+
+	_, err = io.WriteString(mw.w, b.String())
+	return err
+}
+
+func (mw *markdownEntryWriter) Close() error {
+	return nil
+}
+
+// handleExport streams the entries matching the from, to, user and tag
+// filters as CSV, JSON, NDJSON or Markdown. Rows are encoded as they are
+// read, so large exports are never held in memory. The range defaults to
+// all entries up to today.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "json"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown format %q", name), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := s.parseEntryFilter(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := SelectEntriesInRangeQuery
+	if format.byDay {
+		query = SelectEntriesInRangeByDayQuery
+	}
+	rows, err := s.db.Query(query, filter.args()...)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close() // nolint:errcheck
+
+	w.Header().Set("Content-Type", format.contentType)
+	ew := format.newWriter(w)
+
+	for rows.Next() {
+		u, err := scanUpdate(rows)
+		if err != nil {
+			continue
+		}
+		if err := ew.WriteEntry(u); err != nil {
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("export: %v", err)
+		return
+	}
+	ew.Close() // nolint:errcheck
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedSize is the number of most recent entries included in a feed.
+const feedSize = 50
+
+// feedTitleLength caps the entry titles, which are taken from the message.
+const feedTitleLength = 80
+
+// Feed formats, chosen by the extension of the feed URL.
+const (
+	FeedFormatAtom = "atom"
+	FeedFormatRSS  = "rss"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomPerson     `xml:"author"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomText       `xml:"content"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+}
+
+// Feed is the source for an Atom or RSS document.
+type Feed struct {
+	Title   string
+	Self    string
+	Link    string
+	Host    string
+	Updated time.Time
+	Entries []Update
+
+	baseURL string
+}
+
+// parseFeedFile splits a feed file name such as "DAVE.atom" into the name
+// and the feed format.
+func parseFeedFile(file string) (string, string, bool) {
+	if name, ok := strings.CutSuffix(file, "."+FeedFormatAtom); ok && name != "" {
+		return name, FeedFormatAtom, true
+	}
+	if name, ok := strings.CutSuffix(file, "."+FeedFormatRSS); ok && name != "" {
+		return name, FeedFormatRSS, true
+	}
+	return "", "", false
+}
+
+// parseTimestamp parses a stored RFC3339 timestamp, returning the zero time
+// for empty or malformed values.
+func parseTimestamp(ts string) time.Time {
+	t, _ := time.Parse(time.RFC3339, ts)
+	return t
+}
+
+// entryUpdated returns the last time an entry changed: when it was posted,
+// acknowledged or resolved.
+func entryUpdated(u Update) time.Time {
+	t := parseTimestamp(u.Timestamp)
+	if u.Ack != nil {
+		if acked := parseTimestamp(u.Ack.AckedAt); acked.After(t) {
+			t = acked
+		}
+	}
+	if u.Resolution != nil {
+		if resolved := parseTimestamp(u.Resolution.ResolvedAt); resolved.After(t) {
+			t = resolved
+		}
+	}
+	return t
+}
+
+// entryTitle builds a one-line title from the first line of a message.
+func entryTitle(u Update) string {
+	line, _, _ := strings.Cut(u.Message, "\n")
+	if r := []rune(line); len(r) > feedTitleLength {
+		line = string(r[:feedTitleLength-1]) + "…"
+	}
+
+	title := "[" + u.Username + "] "
+	if u.Severity != "" && u.Severity != SeverityInfo {
+		title += u.Severity + ": "
+	}
+	return title + line
+}
+
+// entryContent is the plain-text body of a feed entry.
+func entryContent(u Update) string {
+	content := u.Message
+	if u.Resolution != nil {
+		content += fmt.Sprintf("\n\nResolved by %s: %s", u.Resolution.ResolvedBy, u.Resolution.Note)
+	}
+	return content
+}
+
+// entryURL links to the permalink of an entry.
+func (f *Feed) entryURL(u Update) string {
+	return f.baseURL + "/entry/" + strconv.FormatInt(u.ID, 10)
+}
+
+// entryID is a tag URI (RFC 4151) that stays the same when the entry changes.
+func (f *Feed) entryID(u Update) string {
+	day := parseTimestamp(u.Timestamp).Format(dateLayout)
+	return fmt.Sprintf("tag:%s,%s:entry/%d", f.Host, day, u.ID)
+}
+
+func (f *Feed) atom() any {
+	feed := atomFeed{
+		ID:      f.Self,
+		Title:   f.Title,
+		Updated: f.Updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: f.Self},
+			{Rel: "alternate", Type: "text/html", Href: f.Link},
+		},
+		Entries: []atomEntry{},
+	}
+
+	for _, u := range f.Entries {
+		entry := atomEntry{
+			ID:        f.entryID(u),
+			Title:     entryTitle(u),
+			Published: u.Timestamp,
+			Updated:   entryUpdated(u).Format(time.RFC3339),
+			Author:    atomPerson{Name: u.Username},
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: f.entryURL(u)}},
+			Content:   atomText{Type: "text", Body: entryContent(u)},
+		}
+		for _, tag := range u.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+func (f *Feed) rss() any {
+	channel := rssChannel{
+		Title:         f.Title,
+		Link:          f.Link,
+		Description:   f.Title,
+		LastBuildDate: f.Updated.Format(time.RFC1123Z),
+	}
+
+	for _, u := range f.Entries {
+		channel.Items = append(channel.Items, rssItem{
+			Title:       entryTitle(u),
+			Link:        f.entryURL(u),
+			Description: entryContent(u),
+			GUID:        rssGUID{Value: f.entryID(u)},
+			PubDate:     parseTimestamp(u.Timestamp).Format(time.RFC1123Z),
+			Categories:  u.Tags,
+		})
+	}
+	return rssFeed{Version: "2.0", Channel: channel}
+}
+
+// loadFeed reads the most recent entries, optionally for one user or tag.
+func (s *Server) loadFeed(r *http.Request, title, link, user, tag string) (*Feed, error) {
+	rows, err := s.db.Query(SelectLatestEntriesQuery, user, user, tag, tag, feedSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint:errcheck
+
+	base := s.baseURL(r)
+	host := base
+	if u, err := url.Parse(base); err == nil {
+		host = u.Hostname()
+	}
+
+	f := &Feed{
+		Title:   title,
+		Self:    base + r.URL.Path,
+		Link:    base + link,
+		Host:    host,
+		Updated: time.Unix(0, 0).UTC(),
+		baseURL: base,
+	}
+	for rows.Next() {
+		u, err := scanUpdate(rows)
+		if err != nil {
+			continue
+		}
+		if t := entryUpdated(u); t.After(f.Updated) {
+			f.Updated = t
+		}
+		f.Entries = append(f.Entries, u)
+	}
+	return f, rows.Err()
+}
+
+// serveFeed renders a feed and serves it with an ETag and Last-Modified so
+// feed readers can poll with conditional requests.
+func serveFeed(w http.ResponseWriter, r *http.Request, f *Feed, format string) {
+	doc, contentType := f.atom(), "application/atom+xml; charset=utf-8"
+	if format == FeedFormatRSS {
+		doc, contentType = f.rss(), "application/rss+xml; charset=utf-8"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		http.Error(w, "failed to render feed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(buf.Bytes())))
+	http.ServeContent(w, r, "", f.Updated, bytes.NewReader(buf.Bytes()))
+}
+
+// handleFeed serves the feed of the whole crew at /feeds/all.atom or all.rss.
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	name, format, ok := parseFeedFile(r.PathValue("file"))
+	if !ok || name != "all" {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := s.loadFeed(r, "HAL 9000 // all crew", "/", "", "")
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	serveFeed(w, r, f, format)
+}
+
+// handleUserFeed serves the feed of one crew member.
+func (s *Server) handleUserFeed(w http.ResponseWriter, r *http.Request) {
+	name, format, ok := parseFeedFile(r.PathValue("file"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var user User
+	err := s.db.QueryRow(GetUserByUsernameQuery, strings.ToUpper(name)).Scan(&user.ID, &user.Username)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := s.loadFeed(r, "HAL 9000 // "+user.Username, "/user/"+url.PathEscape(user.Username), user.Username, "")
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	serveFeed(w, r, f, format)
+}
+
+// handleTagFeed serves the feed of entries with a tag or one of its aliases.
+func (s *Server) handleTagFeed(w http.ResponseWriter, r *http.Request) {
+	name, format, ok := parseFeedFile(r.PathValue("file"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	tag := s.canonicalTag(name)
+	if tag == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := s.loadFeed(r, "HAL 9000 // #"+tag, "/search?tag="+url.QueryEscape(tag), "", tag)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	serveFeed(w, r, f, format)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestServer opens a server on a fresh database in a temporary directory.
+func newTestServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+
+	db := openDB(filepath.Join(t.TempDir(), "worklog.db"))
+	t.Cleanup(func() { db.Close() }) // nolint:errcheck
+
+	if cfg.TemplateDir == "" {
+		cfg.TemplateDir = "templates"
+	}
+	cfg.TagPipeline = Must(ParseTagPipeline(DefaultTagPipeline))
+	return NewServer(db, cfg)
+}
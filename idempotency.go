@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxIdempotencyKeyLength limits the Idempotency-Key header.
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// idempotent makes a POST handler safe to retry. When the request carries an
+// Idempotency-Key header, the first successful response is stored against the
+// user and key, and replayed for later requests with the same key until the
+// retention window passes.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+		if key == "" || r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+
+		user, err := s.getUserByToken(r.Header.Get("X-Auth-Token"))
+		if err != nil {
+			// Let the handler report the authentication failure.
+			next(w, r)
+			return
+		}
+
+		// The wrapped handlers accept at most a batch.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBodySize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(append([]byte(r.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+
+		now := time.Now()
+		s.db.Exec(DeleteExpiredIdempotencyKeysQuery, now.Add(-s.cfg.IdempotencyTTL).Unix()) // nolint:errcheck
+
+		// Reserve the key before running the handler so concurrent retries
+		// can't both insert.
+		if _, err := s.db.Exec(ReserveIdempotencyKeyQuery, user.ID, key, hash, now.Unix()); err != nil {
+			if !strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			s.replayIdempotent(w, user.ID, key, hash)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		// Only successful responses are kept; anything else may be retried.
+		if rec.status < 200 || rec.status >= 300 {
+			s.db.Exec(ReleaseIdempotencyKeyQuery, user.ID, key) // nolint:errcheck
+			return
+		}
+
+		_, err = s.db.Exec(CompleteIdempotencyKeyQuery, rec.status, rec.Header().Get("Content-Type"),
+			rec.body.String(), user.ID, key)
+		if err != nil {
+			log.Printf("idempotency: %v", err)
+		}
+	}
+}
+
+// replayIdempotent answers a request whose key has been seen before.
+func (s *Server) replayIdempotent(w http.ResponseWriter, userID int64, key, hash string) {
+	var (
+		storedHash  string
+		status      int
+		contentType string
+		response    string
+	)
+	err := s.db.QueryRow(SelectIdempotencyKeyQuery, userID, key).Scan(&storedHash, &status, &contentType, &response)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	if storedHash != hash {
+		http.Error(w, "Idempotency-Key was used for a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	if status == 0 {
+		http.Error(w, "a request with this Idempotency-Key is in progress", http.StatusConflict)
+		return
+	}
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(status)
+	w.Write([]byte(response)) // nolint:errcheck
+}
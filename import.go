@@ -0,0 +1,694 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxImportRows is the number of records accepted by a single import.
+	maxImportRows = 100000
+
+	// maxImportSize limits the body of an import request.
+	maxImportSize = 32 << 20
+)
+
+// Import formats.
+const (
+	ImportFormatCSV      = "csv"
+	ImportFormatJSON     = "json"
+	ImportFormatMarkdown = "md"
+)
+
+// importTimeLayouts are tried in order when no explicit time format is given.
+// Layouts without a zone are interpreted in local time.
+var importTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02/01/2006 15:04",
+	"02/01/2006",
+}
+
+// ImportMapping names the source column (CSV) or field (JSON) for every
+// entry attribute.
+type ImportMapping struct {
+	User      string
+	Message   string
+	Tags      string
+	Timestamp string
+	Severity  string
+}
+
+// DefaultImportMapping maps every attribute to a column of the same name.
+var DefaultImportMapping = ImportMapping{
+	User:      "user",
+	Message:   "message",
+	Tags:      "tags",
+	Timestamp: "timestamp",
+	Severity:  "severity",
+}
+
+// ParseImportMapping parses a mapping such as "user=Name,message=What",
+// starting from the defaults for any attribute that is not mentioned.
+func ParseImportMapping(v string) (ImportMapping, error) {
+	m := DefaultImportMapping
+	for _, pair := range splitList(v) {
+		key, col, ok := strings.Cut(pair, "=")
+		col = strings.TrimSpace(col)
+		if !ok || col == "" {
+			return m, fmt.Errorf("invalid mapping %q", pair)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "user":
+			m.User = col
+		case "message":
+			m.Message = col
+		case "tags":
+			m.Tags = col
+		case "timestamp":
+			m.Timestamp = col
+		case "severity":
+			m.Severity = col
+		default:
+			return m, fmt.Errorf("unknown mapping field %q", key)
+		}
+	}
+	return m, nil
+}
+
+// ImportOptions controls how records are parsed and imported.
+type ImportOptions struct {
+	Format      string
+	Mapping     ImportMapping
+	TimeFormat  string
+	DefaultUser string
+	CreateUsers bool
+	DryRun      bool
+}
+
+// ImportRecord is one entry read from an import source. Row is the line
+// (CSV, Markdown) or item number (JSON) used when reporting rejections.
+type ImportRecord struct {
+	Row       int
+	User      string
+	Message   string
+	Tags      []string
+	Timestamp string
+	Time      time.Time
+	Severity  string
+	Err       error
+}
+
+// ImportRejection reports a record that was not imported.
+type ImportRejection struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ImportResult summarises an import.
+type ImportResult struct {
+	Imported     int               `json:"imported"`
+	Rejected     []ImportRejection `json:"rejected"`
+	CreatedUsers []User            `json:"created_users,omitempty"`
+	DryRun       bool              `json:"dry_run,omitempty"`
+}
+
+// importFormat guesses the format of a file from its extension.
+func importFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".ndjson", ".jsonl":
+		return ImportFormatJSON
+	case ".md", ".markdown":
+		return ImportFormatMarkdown
+	default:
+		return ImportFormatCSV
+	}
+}
+
+// parseImport reads all records from r in the given format.
+func parseImport(r io.Reader, opts ImportOptions) ([]ImportRecord, error) {
+	switch opts.Format {
+	case ImportFormatCSV:
+		return parseImportCSV(r, opts)
+	case ImportFormatJSON:
+		return parseImportJSON(r, opts.Mapping)
+	case ImportFormatMarkdown:
+		return parseImportMarkdown(r)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", opts.Format)
+	}
+}
+
+// splitImportTags splits a tags cell on commas or semicolons.
+func splitImportTags(v string) []string {
+	return strings.FieldsFunc(v, func(r rune) bool {
+		return r == ',' || r == ';'
+	})
+}
+
+// parseImportCSV reads a CSV file whose first row names the columns.
+func parseImportCSV(r io.Reader, opts ImportOptions) ([]ImportRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	column := func(name string) int {
+		if i, ok := columns[strings.ToLower(name)]; ok {
+			return i
+		}
+		return -1
+	}
+
+	m := opts.Mapping
+	userCol, messageCol := column(m.User), column(m.Message)
+	tagsCol, timestampCol, severityCol := column(m.Tags), column(m.Timestamp), column(m.Severity)
+	if messageCol < 0 {
+		return nil, fmt.Errorf("message column %q not found", m.Message)
+	}
+	if timestampCol < 0 {
+		return nil, fmt.Errorf("timestamp column %q not found", m.Timestamp)
+	}
+	if userCol < 0 && opts.DefaultUser == "" {
+		return nil, fmt.Errorf("user column %q not found and no default user given", m.User)
+	}
+
+	var records []ImportRecord
+	for len(records) <= maxImportRows {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var perr *csv.ParseError
+			if !errors.As(err, &perr) {
+				return nil, err
+			}
+			records = append(records, ImportRecord{Row: perr.StartLine, Err: perr.Err})
+			continue
+		}
+
+		line, _ := cr.FieldPos(0)
+		cell := func(i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+		records = append(records, ImportRecord{
+			Row:       line,
+			User:      cell(userCol),
+			Message:   cell(messageCol),
+			Tags:      splitImportTags(cell(tagsCol)),
+			Timestamp: cell(timestampCol),
+			Severity:  cell(severityCol),
+		})
+	}
+	return records, nil
+}
+
+// jsonImportString converts a JSON value to the text it represents.
+func jsonImportString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// parseImportJSON reads a JSON array or NDJSON stream of objects.
+func parseImportJSON(r io.Reader, m ImportMapping) ([]ImportRecord, error) {
+	raw, err := readBatch(r, maxImportRows)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]ImportRecord, len(raw))
+	for i, item := range raw {
+		rec := &records[i]
+		rec.Row = i + 1
+
+		var fields map[string]any
+		if err := json.Unmarshal(item, &fields); err != nil {
+			rec.Err = errors.New("invalid JSON")
+			continue
+		}
+
+		rec.User = jsonImportString(fields[m.User])
+		rec.Message = jsonImportString(fields[m.Message])
+		rec.Timestamp = jsonImportString(fields[m.Timestamp])
+		rec.Severity = jsonImportString(fields[m.Severity])
+
+		switch tags := fields[m.Tags].(type) {
+		case string:
+			rec.Tags = splitImportTags(tags)
+		case []any:
+			for _, tag := range tags {
+				rec.Tags = append(rec.Tags, jsonImportString(tag))
+			}
+		}
+	}
+	return records, nil
+}
+
+var (
+	markdownHeadingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	markdownDatePattern    = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+	markdownItemPattern    = regexp.MustCompile(`^[-*+]\s+(?:\[?(\d{1,2}:\d{2}(?::\d{2})?)\]?\s+)?(.*)$`)
+
+	// markdownSeverityPattern and markdownResolutionPattern match the
+	// severity and resolution note written by the Markdown export.
+	markdownSeverityPattern   = regexp.MustCompile(`^\*\*(\w+)\*\*\s+`)
+	markdownResolutionPattern = regexp.MustCompile(`\s*_\(resolved by .*\)_$`)
+)
+
+// parseImportMarkdown reads a journal of the form
+//
+//	# 2024-03-01
+//	## DAVE
+//	- 09:30 deployed the antenna fix #deploy
+//	- reviewed the pod bay doors
+//
+// A heading containing a date starts a new day and any other heading names
+// the user; a user heading nested below a date heading applies to that day
+// only. List items are entries, optionally prefixed with a time of day and
+// a bold severity such as **CRIT**; indented lines continue the previous
+// item. Tags come from #hashtags. This is the layout the Markdown export
+// writes; the resolution notes it adds to blockers are dropped.
+func parseImportMarkdown(r io.Reader) ([]ImportRecord, error) {
+	var (
+		records   []ImportRecord
+		day       time.Time
+		dayLevel  int
+		user      string
+		userLevel int
+		current   *ImportRecord
+	)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+
+		if current != nil && trimmed != "" && text != trimmed && !markdownItemPattern.MatchString(trimmed) {
+			current.Message += " " + trimmed
+			continue
+		}
+		current = nil
+
+		if m := markdownHeadingPattern.FindStringSubmatch(trimmed); m != nil {
+			if date := markdownDatePattern.FindString(m[2]); date != "" {
+				t, err := time.ParseInLocation(dateLayout, date, time.Local)
+				if err != nil {
+					records = append(records, ImportRecord{Row: line, Err: fmt.Errorf("invalid date %q", date)})
+					day = time.Time{}
+					continue
+				}
+				day, dayLevel = t, len(m[1])
+				if userLevel > dayLevel {
+					user = ""
+				}
+				continue
+			}
+			user, userLevel = m[2], len(m[1])
+			continue
+		}
+
+		m := markdownItemPattern.FindStringSubmatch(trimmed)
+		if m == nil {
+			continue
+		}
+
+		rec := ImportRecord{Row: line, User: user, Message: strings.TrimSpace(m[2])}
+		if sm := markdownSeverityPattern.FindStringSubmatch(rec.Message); sm != nil {
+			if _, err := ParseSeverity(sm[1]); err == nil {
+				rec.Severity, rec.Message = sm[1], rec.Message[len(sm[0]):]
+			}
+		}
+		switch {
+		case day.IsZero():
+			rec.Err = errors.New("entry before any date heading")
+		case m[1] != "":
+			clock, err := parseClock(m[1])
+			if err != nil {
+				rec.Err = err
+				break
+			}
+			rec.Time = day.Add(clock)
+		default:
+			rec.Time = day
+		}
+
+		records = append(records, rec)
+		if len(records) > maxImportRows {
+			break
+		}
+		current = &records[len(records)-1]
+	}
+
+	for i := range records {
+		records[i].Message = markdownResolutionPattern.ReplaceAllString(records[i].Message, "")
+	}
+	return records, scanner.Err()
+}
+
+// parseClock parses a time of day as HH:MM or HH:MM:SS.
+func parseClock(v string) (time.Duration, error) {
+	layout := "15:04"
+	if strings.Count(v, ":") == 2 {
+		layout = "15:04:05"
+	}
+	t, err := time.Parse(layout, v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", v)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second, nil
+}
+
+// importTime resolves the timestamp of a record. Explicit layouts and
+// layouts without a zone are interpreted in local time.
+func importTime(rec ImportRecord, layout string, now time.Time) (time.Time, error) {
+	t := rec.Time
+	if t.IsZero() {
+		if rec.Timestamp == "" {
+			return t, errors.New("missing timestamp")
+		}
+
+		layouts := importTimeLayouts
+		if layout != "" {
+			layouts = []string{layout}
+		}
+
+		var err error
+		for _, l := range layouts {
+			if t, err = time.ParseInLocation(l, rec.Timestamp, time.Local); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return t, fmt.Errorf("invalid timestamp %q", rec.Timestamp)
+		}
+	}
+
+	if t.After(now) {
+		return t, errors.New("timestamp is in the future")
+	}
+	return t.In(time.Local), nil
+}
+
+// importUser resolves the author of a record within the import transaction,
+// registering missing users when allowed.
+func importUser(tx *sql.Tx, users map[string]*User, username string, opts ImportOptions, result *ImportResult) (*User, error) {
+	if user, ok := users[username]; ok {
+		return user, nil
+	}
+
+	var user User
+	err := tx.QueryRow(GetUserByUsernameQuery, username).Scan(&user.ID, &user.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		if !opts.CreateUsers {
+			return nil, &BadRequestError{Msg: fmt.Sprintf("unknown user %q", username)}
+		}
+
+		user = User{Username: username, Token: generateToken()}
+		res, err := tx.Exec(CreateUserQuery, username, user.Token, time.Now().Format(time.RFC3339), UserKindCrew)
+		if err != nil {
+			return nil, err
+		}
+		user.ID, _ = res.LastInsertId()
+
+		created := user
+		if opts.DryRun {
+			created.ID, created.Token = 0, ""
+		}
+		result.CreatedUsers = append(result.CreatedUsers, created)
+	} else if err != nil {
+		return nil, err
+	}
+
+	users[username] = &user
+	return &user, nil
+}
+
+// importRecords validates the records and inserts the accepted ones with
+// their original timestamps in a single transaction. Imported entries are
+// historical, so they are not broadcast or routed to alerts and webhooks,
+// and CRIT entries are stored as acknowledged so they are never escalated.
+// A dry run reports the same result but rolls everything back.
+func (s *Server) importRecords(records []ImportRecord, opts ImportOptions) (ImportResult, error) {
+	result := ImportResult{Rejected: []ImportRejection{}, DryRun: opts.DryRun}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	now := time.Now()
+	users := map[string]*User{}
+	defaultUser := strings.ToUpper(strings.TrimSpace(opts.DefaultUser))
+
+	for _, rec := range records {
+		u, user, err := s.importRecord(tx, users, rec, defaultUser, opts, now, &result)
+		if err != nil {
+			if !isBadRequest(err) {
+				return result, err
+			}
+			result.Rejected = append(result.Rejected, ImportRejection{Row: rec.Row, Error: err.Error()})
+			continue
+		}
+		if !opts.DryRun {
+			if err := insertUpdateTx(tx, &u, user.ID); err != nil {
+				return result, err
+			}
+			if u.Severity == SeverityCrit {
+				if _, err := tx.Exec(AckEntryQuery, u.Timestamp, nil, u.ID); err != nil {
+					return result, err
+				}
+			}
+		}
+		result.Imported++
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+// importRecord builds the entry for a single record and resolves its author.
+func (s *Server) importRecord(tx *sql.Tx, users map[string]*User, rec ImportRecord, defaultUser string, opts ImportOptions, now time.Time, result *ImportResult) (Update, *User, error) {
+	if rec.Err != nil {
+		return Update{}, nil, &BadRequestError{Msg: rec.Err.Error()}
+	}
+	if rec.Message == "" {
+		return Update{}, nil, &BadRequestError{Msg: "empty message"}
+	}
+
+	username := strings.ToUpper(strings.TrimSpace(rec.User))
+	if username == "" {
+		username = defaultUser
+	}
+	if username == "" {
+		return Update{}, nil, &BadRequestError{Msg: "missing user"}
+	}
+
+	t, err := importTime(rec, opts.TimeFormat, now)
+	if err != nil {
+		return Update{}, nil, &BadRequestError{Msg: err.Error()}
+	}
+
+	severity, err := ParseSeverity(rec.Severity)
+	if err != nil {
+		return Update{}, nil, &BadRequestError{Msg: err.Error()}
+	}
+
+	user, err := importUser(tx, users, username, opts, result)
+	if err != nil {
+		return Update{}, nil, err
+	}
+
+	u, err := s.newUpdate(user, rec.Message, rec.Tags)
+	if err != nil {
+		return Update{}, nil, err
+	}
+	u.Timestamp = t.Format(time.RFC3339)
+	u.Severity = severity
+	// Whether a historical blocker was ever resolved is unknown, so it is
+	// not tracked as an open one.
+	u.Status = ""
+	return u, user, nil
+}
+
+// importOptionsFromRequest reads the import options from the query string.
+func importOptionsFromRequest(r *http.Request) (ImportOptions, error) {
+	q := r.URL.Query()
+
+	mapping, err := ParseImportMapping(q.Get("map"))
+	if err != nil {
+		return ImportOptions{}, err
+	}
+
+	format := strings.ToLower(q.Get("format"))
+	if format == "" {
+		format = ImportFormatCSV
+	}
+
+	return ImportOptions{
+		Format:      format,
+		Mapping:     mapping,
+		TimeFormat:  q.Get("time_format"),
+		DefaultUser: q.Get("user"),
+		CreateUsers: q.Get("create_users") == "true",
+		DryRun:      q.Get("dry_run") == "true",
+	}, nil
+}
+
+// handleImport imports historical entries from a CSV, JSON or Markdown body.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	opts, err := importOptionsFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := parseImport(http.MaxBytesReader(w, r.Body, maxImportSize), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(records) > maxImportRows {
+		http.Error(w, fmt.Sprintf("import too large (max %d records)", maxImportRows), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	result, err := s.importRecords(records, opts)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result) // nolint:errcheck
+}
+
+// runImportCommand implements `hal import`, which imports a file directly
+// into the database without going through a running server.
+func runImportCommand(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dbPath := fs.String("db", "./worklog.db", "path to the SQLite database")
+	format := fs.String("format", "", "input format: csv, json or md (default from the file extension)")
+	mapping := fs.String("map", "", "column mapping, e.g. user=Name,message=Text,timestamp=Date")
+	timeFormat := fs.String("time-format", "", "Go time layout of the timestamp column (default tries common layouts)")
+	defaultUser := fs.String("user", "", "user for records without one")
+	createUsers := fs.Bool("create-users", false, "register users that do not exist yet")
+	dryRun := fs.Bool("dry-run", false, "validate the file without importing anything")
+	stripHashtags := fs.Bool("strip-hashtags", false, "remove #hashtags from messages after extracting them into tags")
+	tagPipeline := fs.String("tag-pipeline", DefaultTagPipeline, "comma-separated tag normalization steps")
+	tagAllowList := fs.Bool("tag-allowlist", false, "reject tags that are not in the managed taxonomy")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: hal import [flags] FILE")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) // nolint:errcheck
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+
+	opts := ImportOptions{
+		Format:      *format,
+		Mapping:     Must(ParseImportMapping(*mapping)),
+		TimeFormat:  *timeFormat,
+		DefaultUser: *defaultUser,
+		CreateUsers: *createUsers,
+		DryRun:      *dryRun,
+	}
+	if opts.Format == "" {
+		opts.Format = importFormat(path)
+	}
+
+	f := Must(os.Open(path))
+	defer f.Close() // nolint:errcheck
+
+	records, err := parseImport(f, opts)
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	if len(records) > maxImportRows {
+		log.Fatalf("%s: too many records (max %d)", path, maxImportRows)
+	}
+
+	s := &Server{
+		db: openDB(*dbPath),
+		cfg: Config{
+			StripHashtags: *stripHashtags,
+			TagPipeline:   Must(ParseTagPipeline(*tagPipeline)),
+			TagAllowList:  *tagAllowList,
+		},
+	}
+	defer s.db.Close() // nolint:errcheck
+
+	result, err := s.importRecords(records, opts)
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+
+	for _, rej := range result.Rejected {
+		fmt.Fprintf(os.Stderr, "%s:%d: %s\n", path, rej.Row, rej.Error)
+	}
+	for _, user := range result.CreatedUsers {
+		if opts.DryRun {
+			fmt.Printf("would create user %s\n", user.Username)
+		} else {
+			fmt.Printf("created user %s (token %s)\n", user.Username, user.Token)
+		}
+	}
+
+	verb := "imported"
+	if opts.DryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %d entries, rejected %d\n", verb, result.Imported, len(result.Rejected))
+}
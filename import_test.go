@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestImportedEntriesAreNotEscalated(t *testing.T) {
+	s := newTestServer(t, Config{EscalationWindow: time.Minute, EscalationMax: 3})
+
+	posted := time.Now().AddDate(0, 0, -2)
+	records := []ImportRecord{
+		{Row: 1, User: "dave", Message: "AE-35 unit failure", Severity: "crit", Time: posted},
+		{Row: 2, User: "dave", Message: "pod bay doors stuck", Tags: []string{"blocker"}, Time: posted},
+	}
+	result, err := s.importRecords(records, ImportOptions{CreateUsers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 2 {
+		t.Fatalf("imported %d records, want 2 (rejected: %v)", result.Imported, result.Rejected)
+	}
+
+	if err := s.escalateOverdue(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	crit, err := s.getUpdate(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crit.EscalationLevel != 0 {
+		t.Errorf("imported CRIT entry escalated to level %d", crit.EscalationLevel)
+	}
+	if crit.Ack == nil {
+		t.Error("imported CRIT entry is not acknowledged")
+	}
+
+	blocker, err := s.getUpdate(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocker.Status != "" {
+		t.Errorf("imported blocker has status %q, want none", blocker.Status)
+	}
+}
+
+func TestMarkdownExportRoundTrip(t *testing.T) {
+	posted := time.Date(2026, 3, 1, 9, 30, 0, 0, time.Local).Format(time.RFC3339)
+	entries := []Update{
+		{Username: "DAVE", Message: "AE-35 unit failing", Tags: []string{"COMMS"}, Severity: SeverityCrit, Timestamp: posted},
+		{
+			Username:   "DAVE",
+			Message:    "pod bay doors stuck",
+			Tags:       []string{"BLOCKER"},
+			Severity:   SeverityWarn,
+			Timestamp:  posted,
+			Resolution: &Resolution{Note: "opened manually", ResolvedBy: "FRANK"},
+		},
+		{Username: "FRANK", Message: "**all** systems nominal", Severity: SeverityInfo, Timestamp: posted},
+	}
+
+	var buf bytes.Buffer
+	mw := newMarkdownEntryWriter(&buf)
+	for _, u := range entries {
+		if err := mw.WriteEntry(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := parseImportMarkdown(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, Config{})
+	result, err := s.importRecords(records, ImportOptions{CreateUsers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != len(entries) {
+		t.Fatalf("imported %d entries, want %d (rejected: %v)\n%s", result.Imported, len(entries), result.Rejected, buf.String())
+	}
+
+	for i, want := range entries {
+		got, err := s.getUpdate(int64(i + 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Username != want.Username || got.Severity != want.Severity || got.Timestamp != want.Timestamp {
+			t.Errorf("entry %d: got %s %s at %s, want %s %s at %s", i+1,
+				got.Username, got.Severity, got.Timestamp, want.Username, want.Severity, want.Timestamp)
+		}
+		if !slices.Equal(got.Tags, want.Tags) {
+			t.Errorf("entry %d: tags %v, want %v", i+1, got.Tags, want.Tags)
+		}
+		if wantMessage := want.Message + hashtags(want.Tags); got.Message != wantMessage {
+			t.Errorf("entry %d: message %q, want %q", i+1, got.Message, wantMessage)
+		}
+	}
+}
+
+// hashtags renders tags the way the Markdown export appends them.
+func hashtags(tags []string) string {
+	var s string
+	for _, tag := range tags {
+		s += " #" + tag
+	}
+	return s
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Integration lets an external tool post entries through a secret URL,
+// attributed to its own integration user.
+type Integration struct {
+	ID        int64    `json:"id"`
+	Name      string   `json:"name"`
+	Username  string   `json:"username"`
+	Tags      []string `json:"tags"`
+	URL       string   `json:"url"`
+	CreatedAt string   `json:"created_at"`
+
+	secret string
+	userID int64
+}
+
+func scanIntegration(row rowScanner) (Integration, error) {
+	var (
+		in   Integration
+		tags string
+	)
+	err := row.Scan(&in.ID, &in.Name, &in.secret, &in.userID, &in.Username, &tags, &in.CreatedAt)
+	if err != nil {
+		return in, err
+	}
+	in.Tags = splitTagsOrEmpty(tags)
+	in.URL = "/hooks/slack/" + in.secret
+	return in, nil
+}
+
+// getOrCreateSystemUser returns the user with the given name, registering
+// it as a system user if needed.
+func (s *Server) getOrCreateSystemUser(username string) (*User, error) {
+	username = strings.ToUpper(strings.TrimSpace(username))
+
+	var user User
+	err := s.db.QueryRow(GetUserByUsernameQuery, username).Scan(&user.ID, &user.Username)
+	if err == nil {
+		return &user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return s.createUser(username, UserKindSystem)
+}
+
+func (s *Server) handleCreateIntegration(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var in struct {
+		Name     string   `json:"name"`
+		Username string   `json:"username"`
+		Tags     []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		http.Error(w, "integration name required", http.StatusBadRequest)
+		return
+	}
+	if in.Username == "" {
+		in.Username = name
+	}
+
+	// Default tags go through the same taxonomy as posted tags, so a
+	// misconfigured integration fails here rather than on every post.
+	tags, err := s.resolveTags(in.Tags)
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	user, err := s.getOrCreateSystemUser(in.Username)
+	if err != nil {
+		http.Error(w, "failed to create integration user", http.StatusInternalServerError)
+		return
+	}
+
+	secret := generateToken()
+	createdAt := time.Now().Format(time.RFC3339)
+	res, err := s.db.Exec(InsertIntegrationQuery, name, secret, user.ID, strings.Join(tags, ","), createdAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			http.Error(w, "integration already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to create integration", http.StatusInternalServerError)
+		return
+	}
+
+	integration := Integration{
+		Name:      name,
+		Username:  user.Username,
+		Tags:      tags,
+		URL:       "/hooks/slack/" + secret,
+		CreatedAt: createdAt,
+	}
+	integration.ID, _ = res.LastInsertId()
+	if integration.Tags == nil {
+		integration.Tags = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(integration) // nolint:errcheck
+}
+
+func (s *Server) handleIntegrations(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	rows, err := s.db.Query(SelectIntegrationsQuery)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close() // nolint:errcheck
+
+	list := []Integration{}
+	for rows.Next() {
+		in, err := scanIntegration(rows)
+		if err != nil {
+			continue
+		}
+		list = append(list, in)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list) // nolint:errcheck
+}
+
+func (s *Server) handleDeleteIntegration(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.db.Exec(DeleteIntegrationQuery, id)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "integration not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SlackPayload is the subset of Slack's incoming webhook format HAL understands.
+type SlackPayload struct {
+	Text   string       `json:"text"`
+	Blocks []SlackBlock `json:"blocks"`
+}
+
+// SlackBlock is a Block Kit block. Only the text-bearing parts are read.
+type SlackBlock struct {
+	Type     string         `json:"type"`
+	Text     *SlackText     `json:"text"`
+	Fields   []SlackText    `json:"fields"`
+	Elements []SlackElement `json:"elements"`
+}
+
+// SlackText is a plain_text or mrkdwn text object.
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// SlackElement is an element of a context or rich_text block. Rich text
+// sections nest further elements.
+type SlackElement struct {
+	Type     string         `json:"type"`
+	Text     string         `json:"text"`
+	URL      string         `json:"url"`
+	Elements []SlackElement `json:"elements"`
+}
+
+// maxSlackPayloadSize limits the size of an incoming Slack payload.
+const maxSlackPayloadSize = 1 << 20
+
+// slackLinkPattern matches Slack's <url|label> and <url> link markup.
+var slackLinkPattern = regexp.MustCompile(`<([^<>|]+)(?:\|([^<>]+))?>`)
+
+// plainSlackText turns Slack mrkdwn links into plain text and unescapes entities.
+func plainSlackText(text string) string {
+	text = slackLinkPattern.ReplaceAllStringFunc(text, func(m string) string {
+		parts := slackLinkPattern.FindStringSubmatch(m)
+		if parts[2] != "" {
+			return parts[2]
+		}
+		return strings.TrimPrefix(parts[1], "mailto:")
+	})
+	r := strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+	return r.Replace(text)
+}
+
+// Message flattens the payload into a single message. Blocks are used when
+// there is no top-level text, as Slack itself does for notifications.
+func (p SlackPayload) Message() string {
+	if strings.TrimSpace(p.Text) != "" {
+		return plainSlackText(strings.TrimSpace(p.Text))
+	}
+
+	var lines []string
+	for _, b := range p.Blocks {
+		if b.Text != nil && b.Text.Text != "" {
+			lines = append(lines, b.Text.Text)
+		}
+		for _, f := range b.Fields {
+			if f.Text != "" {
+				lines = append(lines, f.Text)
+			}
+		}
+		if text := slackElementsText(b.Elements); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return plainSlackText(strings.TrimSpace(strings.Join(lines, "\n")))
+}
+
+func slackElementsText(elements []SlackElement) string {
+	var b strings.Builder
+	for _, e := range elements {
+		switch {
+		case e.Text != "":
+			b.WriteString(e.Text)
+		case e.URL != "":
+			b.WriteString(e.URL)
+		case len(e.Elements) > 0:
+			if b.Len() > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString(slackElementsText(e.Elements))
+		}
+	}
+	return b.String()
+}
+
+// handleSlackHook accepts Slack incoming webhook payloads, as JSON or as the
+// form-encoded payload field, and posts them as the integration's user.
+func (s *Server) handleSlackHook(w http.ResponseWriter, r *http.Request) {
+	integration, err := scanIntegration(s.db.QueryRow(SelectIntegrationBySecretQuery, r.PathValue("secret")))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "no_service", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSlackPayloadSize))
+	if err != nil {
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+		return
+	}
+
+	// Tools often send JSON with a form content type, so the raw body is
+	// tried whenever there is no payload field.
+	raw := body
+	if form, err := url.ParseQuery(string(body)); err == nil && form.Has("payload") {
+		raw = []byte(form.Get("payload"))
+	}
+
+	var payload SlackPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+		return
+	}
+
+	message := payload.Message()
+	if message == "" {
+		http.Error(w, "no_text", http.StatusBadRequest)
+		return
+	}
+
+	user := &User{ID: integration.userID, Username: integration.Username}
+	u, err := s.newUpdate(user, message, integration.Tags)
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+
+	if err := s.insertUpdate(&u, user.ID); err != nil {
+		http.Error(w, "failed to insert update", http.StatusInternalServerError)
+		return
+	}
+
+	s.publish(u)
+
+	// Slack answers a successful post with a plain "ok".
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok")) // nolint:errcheck
+}
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// ircGlobalChannel carries every entry; other channels carry one tag.
+	ircGlobalChannel = "#hal"
+
+	// maxIRCLine is the longest line accepted from a client. RFC 1459
+	// allows 512 bytes; IRCv3 tags may add more.
+	maxIRCLine = 8192
+
+	// ircChunk is how much message text is sent per PRIVMSG, leaving room
+	// for the prefix within the 512 byte line limit.
+	ircChunk = 400
+
+	// ircWriteTimeout drops clients that stop reading, so they can't hold
+	// up the others.
+	ircWriteTimeout = 10 * time.Second
+
+	// ircPingInterval is how often idle clients are pinged. Clients that
+	// stay silent for two intervals are disconnected.
+	ircPingInterval = 2 * time.Minute
+)
+
+// IRCGateway is a minimal IRC server. #hal carries the whole crew log and
+// #<tag> the entries with that tag. Clients authenticate with PASS <token>
+// and are named after their HAL user; what they say in a channel is posted
+// as an entry.
+type IRCGateway struct {
+	Name string
+
+	s *Server
+
+	mu       sync.Mutex
+	channels map[string]map[*ircConn]struct{}
+}
+
+// NewIRCGateway creates a gateway that introduces itself as name.
+func NewIRCGateway(s *Server, name string) *IRCGateway {
+	return &IRCGateway{Name: name, s: s, channels: map[string]map[*ircConn]struct{}{}}
+}
+
+// Serve accepts IRC connections until the listener is closed.
+func (g *IRCGateway) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("irc: %v", err)
+			continue
+		}
+		c := &ircConn{g: g, conn: conn, w: bufio.NewWriter(conn), nick: "*", joined: map[string]string{}, own: map[int64]ircPost{}}
+		go c.serve()
+	}
+}
+
+// ircMessage is a parsed client line: a command and its parameters, the
+// last of which may contain spaces.
+type ircMessage struct {
+	Command string
+	Params  []string
+}
+
+// parseIRC parses a line such as "PRIVMSG #hal :hello there". IRCv3 tags
+// and the prefix are ignored.
+func parseIRC(line string) ircMessage {
+	var m ircMessage
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		if strings.HasPrefix(line, ":") {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		if param == "" {
+			continue
+		}
+		if m.Command == "" {
+			m.Command = strings.ToUpper(param)
+		} else {
+			m.Params = append(m.Params, param)
+		}
+	}
+	return m
+}
+
+// ircText makes text safe to send within one IRC line. Control characters,
+// CR and NUL among them, are dropped so users can't end the line early and
+// inject commands, and line feeds become spaces.
+func ircText(s string) string {
+	return strings.ReplaceAll(sanitizeText(s), "\n", " ")
+}
+
+// ircNick turns a username into a valid nickname.
+func ircNick(username string) string {
+	return strings.NewReplacer(" ", "_", ",", "_", "!", "_", "@", "_").Replace(ircText(username))
+}
+
+// ircChunks splits a message into lines short enough for PRIVMSG, without
+// breaking UTF-8 sequences. Control characters are dropped like in ircText.
+func ircChunks(text string) []string {
+	var chunks []string
+	for line := range strings.SplitSeq(sanitizeText(text), "\n") {
+		for len(line) > ircChunk {
+			n := ircChunk
+			for n > 0 && !utf8.RuneStart(line[n]) {
+				n--
+			}
+			chunks = append(chunks, line[:n])
+			line = line[n:]
+		}
+		if line != "" {
+			chunks = append(chunks, line)
+		}
+	}
+	return chunks
+}
+
+// ircConn is one client connection.
+type ircConn struct {
+	g    *IRCGateway
+	conn net.Conn
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	// Registration state, only touched by the reading goroutine.
+	pass       string
+	nick       string
+	gotUser    bool
+	registered bool
+	user       *User
+
+	// joined maps the channels joined to their tag ("" for #hal); own
+	// remembers entries posted from a channel so they aren't echoed back
+	// to it. Both are read by the relay goroutine.
+	mu     sync.Mutex
+	joined map[string]string
+	own    map[int64]ircPost
+}
+
+// ircPost is an entry posted from a channel, waiting to come back from the
+// broadcaster. Entries the broadcaster dropped never do, so they are
+// forgotten after a while.
+type ircPost struct {
+	channel string
+	at      time.Time
+}
+
+// send writes a line to the client.
+func (c *ircConn) send(format string, args ...any) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(ircWriteTimeout)) // nolint:errcheck
+	fmt.Fprintf(c.w, format+"\r\n", args...)                 // nolint:errcheck
+	c.w.Flush()                                              // nolint:errcheck
+}
+
+// numeric sends a numeric reply from the server.
+func (c *ircConn) numeric(code string, params string) {
+	c.send(":%s %s %s %s", c.g.Name, code, c.nick, params)
+}
+
+// source is the prefix of messages sent on behalf of the client.
+func (c *ircConn) source() string {
+	return c.nick + "!" + c.nick + "@" + c.g.Name
+}
+
+func (c *ircConn) serve() {
+	defer c.conn.Close() // nolint:errcheck
+
+	br := bufio.NewReaderSize(c.conn, maxIRCLine)
+	var stop chan struct{}
+	reason := "Connection closed"
+	defer func() {
+		if stop != nil {
+			close(stop)
+		}
+		c.partAll(reason)
+	}()
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(2*ircPingInterval + 30*time.Second)) // nolint:errcheck
+
+		line, err := br.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// Drop overlong lines.
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = br.ReadSlice('\n')
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		m := parseIRC(strings.TrimRight(string(line), "\r\n"))
+		if m.Command == "" {
+			continue
+		}
+
+		if !c.registered {
+			if !c.register(m) {
+				return
+			}
+			if c.registered {
+				stop = make(chan struct{})
+				go c.relay(stop)
+			}
+			continue
+		}
+		if m.Command == "QUIT" {
+			if len(m.Params) > 0 && m.Params[0] != "" {
+				reason = "Quit: " + m.Params[0]
+			}
+			c.send("ERROR :Closing link")
+			return
+		}
+		c.handle(m)
+	}
+}
+
+// register handles the commands allowed before registration. It returns
+// false when the connection should be closed.
+func (c *ircConn) register(m ircMessage) bool {
+	switch m.Command {
+	case "CAP":
+		if len(m.Params) > 0 && strings.EqualFold(m.Params[0], "LS") {
+			c.send(":%s CAP * LS :", c.g.Name)
+		} else if len(m.Params) > 1 && strings.EqualFold(m.Params[0], "REQ") {
+			c.send(":%s CAP * NAK :%s", c.g.Name, m.Params[1])
+		}
+		return true
+	case "PASS":
+		if len(m.Params) == 0 {
+			c.numeric("461", "PASS :Not enough parameters")
+			return true
+		}
+		c.pass = m.Params[0]
+		return true
+	case "NICK":
+		if len(m.Params) == 0 {
+			c.numeric("431", ":No nickname given")
+			return true
+		}
+		c.nick = m.Params[0]
+	case "USER":
+		if len(m.Params) < 4 {
+			c.numeric("461", "USER :Not enough parameters")
+			return true
+		}
+		c.gotUser = true
+	case "PING":
+		c.send(":%s PONG %s :%s", c.g.Name, c.g.Name, strings.Join(m.Params, " "))
+		return true
+	case "QUIT":
+		return false
+	default:
+		c.numeric("451", ":You have not registered")
+		return true
+	}
+
+	if !c.gotUser || c.nick == "*" {
+		return true
+	}
+
+	user, err := c.g.s.getUserByToken(c.pass)
+	if c.pass == "" || err != nil {
+		c.numeric("464", ":Password incorrect, use your HAL token as the server password")
+		c.send("ERROR :Closing link: invalid token")
+		return false
+	}
+	c.user = user
+	c.registered = true
+
+	// The nickname is always the HAL username; clients pick it up from
+	// the welcome message.
+	c.nick = ircNick(user.Username)
+	c.numeric("001", fmt.Sprintf(":Welcome to the HAL 9000 IRC gateway, %s", c.nick))
+	c.numeric("002", fmt.Sprintf(":Your host is %s", c.g.Name))
+	c.numeric("003", ":This server is the HAL 9000 crew log")
+	c.numeric("004", fmt.Sprintf("%s hal o nt", c.g.Name))
+	c.numeric("375", fmt.Sprintf(":- %s Message of the day -", c.g.Name))
+	c.numeric("372", fmt.Sprintf(":- Join %s for the crew log, or #<tag> for one tag.", ircGlobalChannel))
+	c.numeric("372", ":- Whatever you say in a channel is posted as an entry; start with [WARN] or [CRIT] to set its severity.")
+	c.numeric("376", ":End of /MOTD command")
+	return true
+}
+
+// handle runs a command of a registered client.
+func (c *ircConn) handle(m ircMessage) {
+	switch m.Command {
+	case "PING":
+		c.send(":%s PONG %s :%s", c.g.Name, c.g.Name, strings.Join(m.Params, " "))
+	case "PONG", "CAP", "PASS", "USER":
+	case "NICK":
+		c.numeric("432", fmt.Sprintf("%s :Your nickname is your HAL username", strings.Join(m.Params, " ")))
+	case "JOIN":
+		if len(m.Params) == 0 {
+			c.numeric("461", "JOIN :Not enough parameters")
+			break
+		}
+		for name := range strings.SplitSeq(m.Params[0], ",") {
+			c.join(name)
+		}
+	case "PART":
+		if len(m.Params) == 0 {
+			c.numeric("461", "PART :Not enough parameters")
+			break
+		}
+		for name := range strings.SplitSeq(m.Params[0], ",") {
+			c.part(name, "Leaving")
+		}
+	case "PRIVMSG", "NOTICE":
+		if len(m.Params) < 2 {
+			c.numeric("412", ":No text to send")
+			break
+		}
+		c.privmsg(m.Params[0], m.Params[1], m.Command == "NOTICE")
+	case "NAMES":
+		if len(m.Params) > 0 {
+			for name := range strings.SplitSeq(m.Params[0], ",") {
+				c.names(strings.ToLower(name))
+			}
+		}
+	case "TOPIC":
+		if len(m.Params) > 0 {
+			c.topic(strings.ToLower(m.Params[0]))
+		}
+	case "MODE":
+		switch {
+		case len(m.Params) == 0:
+			c.numeric("461", "MODE :Not enough parameters")
+		case !strings.HasPrefix(m.Params[0], "#"):
+			c.numeric("221", "+")
+		case len(m.Params) > 1 && strings.Contains(m.Params[1], "b"):
+			c.numeric("368", m.Params[0]+" :End of channel ban list")
+		default:
+			c.numeric("324", m.Params[0]+" +nt")
+		}
+	case "WHO":
+		target := "*"
+		if len(m.Params) > 0 {
+			target = m.Params[0]
+		}
+		c.numeric("315", target+" :End of /WHO list")
+	case "LIST":
+		c.list()
+	default:
+		c.numeric("421", m.Command+" :Unknown command")
+	}
+}
+
+// channelTag validates a channel name and returns the tag it carries.
+func (c *ircConn) channelTag(name string) (string, bool) {
+	if len(name) < 2 || len(name) > 50 || name[0] != '#' || strings.ContainsAny(name, " ,") || strings.ContainsFunc(name, unicode.IsControl) {
+		return "", false
+	}
+	if name == ircGlobalChannel {
+		return "", true
+	}
+	tag := c.g.s.canonicalTag(name[1:])
+	return tag, tag != ""
+}
+
+func (c *ircConn) join(name string) {
+	name = strings.ToLower(name)
+	tag, ok := c.channelTag(name)
+	if !ok {
+		c.numeric("403", name+" :No such channel")
+		return
+	}
+
+	c.mu.Lock()
+	_, already := c.joined[name]
+	c.joined[name] = tag
+	c.mu.Unlock()
+	if already {
+		return
+	}
+
+	c.g.mu.Lock()
+	members, ok := c.g.channels[name]
+	if !ok {
+		members = map[*ircConn]struct{}{}
+		c.g.channels[name] = members
+	}
+	members[c] = struct{}{}
+	others := slices.Collect(maps.Keys(members))
+	c.g.mu.Unlock()
+
+	// Send outside the lock, so a slow client can't hold up the gateway.
+	for _, other := range others {
+		other.send(":%s JOIN %s", c.source(), name)
+	}
+
+	c.topic(name)
+	c.names(name)
+}
+
+// part leaves a channel, telling the other members.
+func (c *ircConn) part(name, reason string) {
+	name = strings.ToLower(name)
+
+	c.mu.Lock()
+	_, ok := c.joined[name]
+	delete(c.joined, name)
+	c.mu.Unlock()
+	if !ok {
+		c.numeric("442", name+" :You're not on that channel")
+		return
+	}
+
+	c.g.mu.Lock()
+	members := c.g.channels[name]
+	others := slices.Collect(maps.Keys(members))
+	delete(members, c)
+	if len(members) == 0 {
+		delete(c.g.channels, name)
+	}
+	c.g.mu.Unlock()
+
+	for _, other := range others {
+		other.send(":%s PART %s :%s", c.source(), name, ircText(reason))
+	}
+}
+
+// partAll removes a closing connection from its channels, telling the
+// other members it quit.
+func (c *ircConn) partAll(reason string) {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.joined))
+	for name := range c.joined {
+		names = append(names, name)
+	}
+	c.joined = map[string]string{}
+	c.mu.Unlock()
+
+	c.g.mu.Lock()
+	others := map[*ircConn]struct{}{}
+	for _, name := range names {
+		members := c.g.channels[name]
+		delete(members, c)
+		maps.Copy(others, members)
+		if len(members) == 0 {
+			delete(c.g.channels, name)
+		}
+	}
+	c.g.mu.Unlock()
+
+	for other := range others {
+		other.send(":%s QUIT :%s", c.source(), ircText(reason))
+	}
+}
+
+func (c *ircConn) topic(name string) {
+	c.mu.Lock()
+	tag, ok := c.joined[name]
+	c.mu.Unlock()
+	if !ok {
+		c.numeric("442", name+" :You're not on that channel")
+		return
+	}
+
+	if tag == "" {
+		c.numeric("332", name+" :HAL 9000 crew log")
+	} else {
+		c.numeric("332", fmt.Sprintf("%s :HAL 9000 entries tagged %s", name, tag))
+	}
+}
+
+// names lists HAL and the IRC users in a channel.
+func (c *ircConn) names(name string) {
+	nicks := []string{"HAL"}
+
+	c.g.mu.Lock()
+	for other := range c.g.channels[name] {
+		if !slices.Contains(nicks, other.nick) {
+			nicks = append(nicks, other.nick)
+		}
+	}
+	c.g.mu.Unlock()
+
+	c.numeric("353", fmt.Sprintf("= %s :%s", name, strings.Join(nicks, " ")))
+	c.numeric("366", name+" :End of /NAMES list")
+}
+
+// list shows #hal and the channels someone has joined.
+func (c *ircConn) list() {
+	c.g.mu.Lock()
+	counts := map[string]int{ircGlobalChannel: 0}
+	for name, members := range c.g.channels {
+		counts[name] = len(members)
+	}
+	c.g.mu.Unlock()
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	c.numeric("321", "Channel :Users Name")
+	for _, name := range names {
+		c.numeric("322", fmt.Sprintf("%s %d :", name, counts[name]))
+	}
+	c.numeric("323", ":End of /LIST")
+}
+
+// ircUpdateRequest builds an update from a channel message. A leading
+// [WARN] or [CRIT] sets the severity, matching how entries are relayed.
+func ircUpdateRequest(text, tag string) UpdateRequest {
+	in := UpdateRequest{Message: strings.TrimSpace(text)}
+	if tag != "" {
+		in.Tags = []string{tag}
+	}
+	if strings.HasPrefix(in.Message, "[") {
+		if label, rest, ok := strings.Cut(in.Message[1:], "]"); ok {
+			if severity, err := ParseSeverity(label); err == nil {
+				in.Severity = severity
+				in.Message = strings.TrimSpace(rest)
+			}
+		}
+	}
+	return in
+}
+
+// privmsg posts a channel message as an entry.
+func (c *ircConn) privmsg(target, text string, notice bool) {
+	target = strings.ToLower(target)
+	if !strings.HasPrefix(target, "#") {
+		if !notice {
+			c.numeric("401", target+" :Private messages are not supported")
+		}
+		return
+	}
+
+	c.mu.Lock()
+	tag, ok := c.joined[target]
+	c.mu.Unlock()
+	if !ok {
+		if !notice {
+			c.numeric("404", target+" :Cannot send to channel, join it first")
+		}
+		return
+	}
+
+	// CTCP: /me actions are posted as plain text, anything else ignored.
+	if strings.HasPrefix(text, "\x01") {
+		action, ok := strings.CutPrefix(strings.Trim(text, "\x01"), "ACTION ")
+		if !ok {
+			return
+		}
+		text = action
+	}
+
+	u, err := c.g.s.updateFromRequest(c.user, ircUpdateRequest(text, tag))
+	if err != nil {
+		if isBadRequest(err) {
+			c.send(":%s NOTICE %s :Not posted: %v", c.g.Name, c.nick, err)
+		} else {
+			c.send(":%s NOTICE %s :Not posted: database error", c.g.Name, c.nick)
+		}
+		return
+	}
+
+	// Hold the lock across the insert so the relay can't see the entry
+	// before it is recorded as ours.
+	c.mu.Lock()
+	err = c.g.s.insertUpdate(&u, c.user.ID)
+	if err == nil {
+		c.own[u.ID] = ircPost{channel: target, at: time.Now()}
+	}
+	c.mu.Unlock()
+	if err != nil {
+		c.send(":%s NOTICE %s :Not posted: failed to insert update", c.g.Name, c.nick)
+		return
+	}
+	c.g.s.publish(u)
+}
+
+// relay sends entries from the broadcaster to the channels they belong in,
+// and pings the client periodically.
+func (c *ircConn) relay(stop <-chan struct{}) {
+	ch := make(chan Update, 64)
+	c.g.s.addClient(ch)
+	defer c.g.s.removeClient(ch)
+
+	ping := time.NewTicker(ircPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ping.C:
+			c.send("PING :%s", c.g.Name)
+
+			c.mu.Lock()
+			for id, post := range c.own {
+				if time.Since(post.at) > ircPingInterval {
+					delete(c.own, id)
+				}
+			}
+			c.mu.Unlock()
+		case u := <-ch:
+			c.mu.Lock()
+			post, own := c.own[u.ID]
+			if own && u.Event == "" {
+				delete(c.own, u.ID)
+			}
+			var targets []string
+			for name, tag := range c.joined {
+				if own && u.Event == "" && name == post.channel {
+					continue
+				}
+				if tag == "" || slices.ContainsFunc(u.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+					targets = append(targets, name)
+				}
+			}
+			c.mu.Unlock()
+
+			for _, name := range targets {
+				c.deliver(name, u)
+			}
+		}
+	}
+}
+
+// deliver relays an entry to a channel: new entries as messages from their
+// author, changes to existing entries as notices from HAL.
+func (c *ircConn) deliver(name string, u Update) {
+	if u.Event != "" {
+		line, _, _ := strings.Cut(u.Message, "\n")
+		c.send(":HAL!hal@%s NOTICE %s :entry %d by %s %s: %s", c.g.Name, name, u.ID, ircText(u.Username), u.Event, ircText(line))
+		return
+	}
+
+	text := u.Message
+	if u.Severity != "" && u.Severity != SeverityInfo {
+		text = "[" + u.Severity + "] " + text
+	}
+	if name == ircGlobalChannel && len(u.Tags) > 0 {
+		text += " [" + strings.Join(u.Tags, " ") + "]"
+	}
+
+	nick := ircNick(u.Username)
+	for _, chunk := range ircChunks(text) {
+		c.send(":%s!%s@%s PRIVMSG %s :%s", nick, nick, c.g.Name, name, chunk)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIRCChunksDropControlCharacters(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "bare CR",
+			text: "pod bay doors\rQUIT :bye",
+			want: []string{"pod bay doorsQUIT :bye"},
+		},
+		{
+			name: "CRLF",
+			text: "pod bay doors\r\nopen",
+			want: []string{"pod bay doors", "open"},
+		},
+		{
+			name: "NUL",
+			text: "pod bay\x00 doors",
+			want: []string{"pod bay doors"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ircChunks(tt.text)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIRCNickIsOneWord(t *testing.T) {
+	got := ircNick("dave\r\nQUIT\x00 x")
+	if strings.ContainsAny(got, "\r\n\x00 ") {
+		t.Errorf("nick %q contains a line break, NUL or space", got)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mailer sends mail through a configured SMTP server.
+type Mailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// MailMessage is a message with a plain-text body and an optional HTML
+// alternative. Headers are added to the message as given.
+type MailMessage struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string
+}
+
+// Send delivers a plain-text message to the given recipients.
+func (m *Mailer) Send(to []string, subject, body string) error {
+	return m.SendMessage(&MailMessage{To: to, Subject: subject, Text: body})
+}
+
+// SendMessage delivers a message, as multipart/alternative when it has an
+// HTML body.
+func (m *Mailer) SendMessage(msg *MailMessage) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	names := make([]string, 0, len(msg.Headers))
+	for name := range msg.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, msg.Headers[name])
+	}
+
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("\r\n")
+		buf.WriteString(strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+	} else if err := writeAlternative(&buf, msg.Text, msg.HTML); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, msg.To, buf.Bytes())
+}
+
+// writeAlternative writes the Content-Type header and a multipart body with
+// quoted-printable text and HTML parts.
+func writeAlternative(buf *bytes.Buffer, text, html string) error {
+	mw := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n", mw.Boundary())
+	buf.WriteString("\r\n")
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	}
+	for _, p := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qw := quotedprintable.NewWriter(w)
+		if _, err := qw.Write([]byte(strings.ReplaceAll(p.body, "\n", "\r\n"))); err != nil {
+			return err
+		}
+		if err := qw.Close(); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
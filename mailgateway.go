@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// maxMailSize limits the size of a message accepted by the mail gateway.
+	maxMailSize = 1 << 20
+
+	// maxMailRecipients limits the recipients of a single message.
+	maxMailRecipients = 20
+
+	// mailIdleTimeout closes SMTP connections that stop sending commands.
+	mailIdleTimeout = 5 * time.Minute
+)
+
+// MailGateway accepts mail over SMTP and turns each message into an entry.
+// Crew post by sending mail to the configured address with their token
+// after a plus sign, e.g. log+a1b2c3@hal for log@hal.
+type MailGateway struct {
+	Local  string
+	Domain string
+
+	s *Server
+}
+
+// NewMailGateway creates a gateway for an address such as "log@hal".
+func NewMailGateway(s *Server, address string) (*MailGateway, error) {
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok || local == "" || domain == "" || strings.Contains(local, "+") {
+		return nil, fmt.Errorf("invalid mail gateway address %q", address)
+	}
+	return &MailGateway{Local: local, Domain: domain, s: s}, nil
+}
+
+// Serve accepts SMTP connections until the listener is closed.
+func (g *MailGateway) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("mail: %v", err)
+			continue
+		}
+		go g.serveConn(conn)
+	}
+}
+
+// mailPath extracts the address from a MAIL FROM or RCPT TO argument,
+// ignoring any ESMTP parameters after it.
+func mailPath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	if i := strings.IndexByte(path, ' '); i >= 0 {
+		path = path[:i]
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(path, "<"), ">"), true
+}
+
+// recipientToken returns the token in a recipient such as log+<token>@hal.
+func (g *MailGateway) recipientToken(addr string) (string, error) {
+	i := strings.LastIndexByte(addr, '@')
+	if i < 0 || !strings.EqualFold(addr[i+1:], g.Domain) {
+		return "", errors.New("relay not permitted")
+	}
+	local, token, _ := strings.Cut(addr[:i], "+")
+	if !strings.EqualFold(local, g.Local) {
+		return "", errors.New("no such mailbox")
+	}
+	if token == "" {
+		return "", fmt.Errorf("send to %s+<token>@%s", g.Local, g.Domain)
+	}
+	return token, nil
+}
+
+// serveConn runs one SMTP session. Every recipient with a valid token
+// receives the message as an entry of their own. Tokens are only checked
+// once the message is in, and the replies are the same either way, so the
+// gateway can't be used to guess tokens.
+func (g *MailGateway) serveConn(conn net.Conn) {
+	defer conn.Close() // nolint:errcheck
+
+	tp := textproto.NewConn(conn)
+	var (
+		from   string
+		tokens []string
+	)
+
+	tp.PrintfLine("220 %s HAL 9000 mail gateway ready", g.Domain) // nolint:errcheck
+	for {
+		conn.SetDeadline(time.Now().Add(mailIdleTimeout)) // nolint:errcheck
+
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			tp.PrintfLine("250 %s", g.Domain) // nolint:errcheck
+		case "EHLO":
+			tp.PrintfLine("250-%s", g.Domain)         // nolint:errcheck
+			tp.PrintfLine("250-SIZE %d", maxMailSize) // nolint:errcheck
+			tp.PrintfLine("250 8BITMIME")             // nolint:errcheck
+		case "MAIL":
+			path, ok := mailPath(arg, "FROM:")
+			if !ok {
+				tp.PrintfLine("501 syntax: MAIL FROM:<address>") // nolint:errcheck
+				continue
+			}
+			from, tokens = path, nil
+			if from == "" {
+				from = "<>"
+			}
+			tp.PrintfLine("250 ok") // nolint:errcheck
+		case "RCPT":
+			if from == "" {
+				tp.PrintfLine("503 MAIL FROM first") // nolint:errcheck
+				continue
+			}
+			path, ok := mailPath(arg, "TO:")
+			if !ok {
+				tp.PrintfLine("501 syntax: RCPT TO:<address>") // nolint:errcheck
+				continue
+			}
+			if len(tokens) >= maxMailRecipients {
+				tp.PrintfLine("452 too many recipients") // nolint:errcheck
+				continue
+			}
+			token, err := g.recipientToken(path)
+			if err != nil {
+				tp.PrintfLine("550 %v", err) // nolint:errcheck
+				continue
+			}
+			tokens = append(tokens, token)
+			tp.PrintfLine("250 ok") // nolint:errcheck
+		case "DATA":
+			if len(tokens) == 0 {
+				tp.PrintfLine("503 RCPT TO first") // nolint:errcheck
+				continue
+			}
+			tp.PrintfLine("354 end data with <CR><LF>.<CR><LF>") // nolint:errcheck
+
+			dr := tp.DotReader()
+			data, err := io.ReadAll(io.LimitReader(dr, maxMailSize+1))
+			if err != nil {
+				return
+			}
+			if len(data) > maxMailSize {
+				io.Copy(io.Discard, dr)                // nolint:errcheck
+				tp.PrintfLine("552 message too large") // nolint:errcheck
+			} else {
+				tp.PrintfLine("%s", g.deliver(tokens, data)) // nolint:errcheck
+			}
+			from, tokens = "", nil
+		case "RSET":
+			from, tokens = "", nil
+			tp.PrintfLine("250 ok") // nolint:errcheck
+		case "NOOP":
+			tp.PrintfLine("250 ok") // nolint:errcheck
+		case "QUIT":
+			tp.PrintfLine("221 bye") // nolint:errcheck
+			return
+		default:
+			tp.PrintfLine("502 command not implemented") // nolint:errcheck
+		}
+	}
+}
+
+// deliver posts a message for every recipient with a valid token and
+// returns the SMTP reply. The entries are inserted in one transaction, so
+// a failure posts none of them and the sender's retry can't duplicate any.
+func (g *MailGateway) deliver(tokens []string, data []byte) string {
+	subject, body, err := parseMail(data)
+	if err != nil {
+		return "554 unreadable message"
+	}
+	in := mailUpdateRequest(subject, body)
+
+	// Validate the message before looking at the tokens, so the reply
+	// doesn't tell whether any of them are valid.
+	if _, err := g.s.updateFromRequest(&User{}, in); err != nil {
+		if isBadRequest(err) {
+			return "554 " + err.Error()
+		}
+		return "451 database error"
+	}
+
+	var (
+		list    []Update
+		userIDs []int64
+	)
+	for _, token := range tokens {
+		user, err := g.s.getUserByToken(token)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("mail: dropped a message sent to an invalid token")
+			continue
+		}
+		if err != nil {
+			return "451 database error"
+		}
+		if slices.Contains(userIDs, user.ID) {
+			continue
+		}
+
+		u, err := g.s.updateFromRequest(user, in)
+		if err != nil {
+			return "451 database error"
+		}
+		list = append(list, u)
+		userIDs = append(userIDs, user.ID)
+	}
+
+	tx, err := g.s.db.Begin()
+	if err != nil {
+		return "451 database error"
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	for i := range list {
+		if err := insertUpdateTx(tx, &list[i], userIDs[i]); err != nil {
+			return "451 failed to insert update"
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "451 failed to insert update"
+	}
+	g.s.publishAll(list)
+	return "250 ok"
+}
+
+// parseMail returns the decoded subject and plain-text body of a message.
+func parseMail(data []byte) (string, string, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		return "", "", err
+	}
+
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	body, err := textBody(textproto.MIMEHeader(msg.Header), msg.Body)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(subject), cleanMailBody(body), nil
+}
+
+// textBody returns the first text/plain part of a message body, decoding
+// its transfer encoding.
+func textBody(header textproto.MIMEHeader, r io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := textBody(part.Header, part)
+			if err != nil {
+				return "", err
+			}
+			if text != "" {
+				return text, nil
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+// cleanMailBody drops the signature and quoted lines of a reply.
+func cleanMailBody(body string) string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(body, "\r\n", "\n")))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "-- " || line == "--" {
+			break
+		}
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \t"))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+var (
+	// mailTagPattern matches [tag] groups in a subject line.
+	mailTagPattern = regexp.MustCompile(`\[([^\]]*)\]`)
+
+	// mailReplyPattern matches reply and forward prefixes of a subject line.
+	mailReplyPattern = regexp.MustCompile(`(?i)^((re|fwd?|aw)\s*:\s*)+`)
+)
+
+// mailUpdateRequest builds an update from a message. [tag] groups in the
+// subject become tags, or the severity when they name one; #hashtags are
+// picked up from the message like for any other update. The rest of the
+// subject is the first line of the message and the body follows it.
+func mailUpdateRequest(subject, body string) UpdateRequest {
+	var in UpdateRequest
+
+	subject = mailReplyPattern.ReplaceAllString(subject, "")
+	for _, m := range mailTagPattern.FindAllStringSubmatch(subject, -1) {
+		for _, tag := range strings.Split(m[1], ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "" {
+				continue
+			}
+			if severity, err := ParseSeverity(tag); err == nil {
+				in.Severity = severity
+				continue
+			}
+			in.Tags = append(in.Tags, tag)
+		}
+	}
+	subject = strings.Join(strings.Fields(mailTagPattern.ReplaceAllString(subject, " ")), " ")
+
+	switch {
+	case subject == "":
+		in.Message = body
+	case body == "":
+		in.Message = subject
+	default:
+		in.Message = subject + "\n\n" + body
+	}
+	return in
+}
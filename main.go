@@ -8,9 +8,13 @@ import (
 
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"strings"
+	"time"
 )
 
 // Must is a helper function to handle errors.
@@ -23,16 +27,155 @@ func Must[T any](v T, err error) T {
 	return v
 }
 
+// migrate runs an ALTER TABLE ... ADD COLUMN query, ignoring the error
+// SQLite reports when the column already exists.
+func migrate(db *sql.DB, query string) {
+	if _, err := db.Exec(query); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Fatal(err)
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(v string) []string {
+	var list []string
+	for item := range strings.SplitSeq(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// openDB opens the worklog database, creating and migrating its tables.
+func openDB(path string) *sql.DB {
+	db := Must(sql.Open("sqlite3", path))
+
+	Must(db.Exec(CreateUsersTableQuery))
+	migrate(db, AddUserKindColumnQuery)
+	Must(db.Exec(CreateTableQuery))
+	migrate(db, AddEntryKindColumnQuery)
+	migrate(db, AddEntryStatusColumnQuery)
+	migrate(db, AddEntryResolutionColumnQuery)
+	migrate(db, AddEntryResolvedAtColumnQuery)
+	migrate(db, AddEntryResolvedByColumnQuery)
+	migrate(db, AddEntrySeverityColumnQuery)
+	migrate(db, AddEntryAckedAtColumnQuery)
+	migrate(db, AddEntryAckedByColumnQuery)
+	migrate(db, AddEntryEscalationLevelColumnQuery)
+	migrate(db, AddEntryEscalatedAtColumnQuery)
+	Must(db.Exec(CreateEscalationsTableQuery))
+	Must(db.Exec(CreateWebhooksTableQuery))
+	Must(db.Exec(CreateWebhookDeliveriesTableQuery))
+	Must(db.Exec(CreateIntegrationsTableQuery))
+	Must(db.Exec(CreateIdempotencyKeysTableQuery))
+	Must(db.Exec(CreateDigestSubscriptionsTableQuery))
+	Must(db.Exec(CreateSSHKeysTableQuery))
+	Must(db.Exec(CreateStandupsTableQuery))
+	Must(db.Exec(CreateTagsTableQuery))
+	Must(db.Exec(CreateTagAliasesTableQuery))
+	return db
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImportCommand(os.Args[2:])
+		return
+	}
+
 	addr := flag.String("addr", ":8080", "listen address")
+	dbPath := flag.String("db", "./worklog.db", "path to the SQLite database")
+	publicURL := flag.String("public-url", "", "external base URL used for absolute links (default from the request)")
+	templateDir := flag.String("templates", "templates", "directory with the page templates")
+	stripHashtags := flag.Bool("strip-hashtags", false, "remove #hashtags from messages after extracting them into tags")
+	tagPipeline := flag.String("tag-pipeline", DefaultTagPipeline, "comma-separated tag normalization steps (trim, upper, lower, underscore, dash, alnum, collapse)")
+	tagAllowList := flag.Bool("tag-allowlist", false, "reject tags that are not in the managed taxonomy")
+	adminToken := flag.String("admin-token", os.Getenv("HAL_ADMIN_TOKEN"), "token for the admin endpoints (default $HAL_ADMIN_TOKEN)")
+	alertWebhooks := flag.String("alert-webhooks", "", "comma-separated URLs that receive CRIT entries")
+	alertEmails := flag.String("alert-emails", "", "comma-separated addresses that receive CRIT entries by email")
+	escalationWindow := flag.Duration("escalation-window", 0, "escalate CRIT entries not acknowledged within this window (0 disables)")
+	escalationMax := flag.Int("escalation-max", 3, "maximum number of escalations per entry")
+	escalationWebhooks := flag.String("escalation-webhooks", "", "comma-separated URLs notified when an entry is escalated")
+	webhookMaxAttempts := flag.Int("webhook-max-attempts", 8, "delivery attempts before a webhook delivery is dead-lettered")
+	webhookBackoff := flag.Duration("webhook-backoff", 30*time.Second, "delay before the first webhook retry, doubled on every further attempt")
+	idempotencyTTL := flag.Duration("idempotency-ttl", 24*time.Hour, "how long Idempotency-Key responses are kept for replay")
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server (host:port) used to send mail")
+	smtpFrom := flag.String("smtp-from", "hal@discovery.one", "sender address for outgoing mail")
+	smtpUser := flag.String("smtp-user", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", os.Getenv("HAL_SMTP_PASSWORD"), "SMTP password (default $HAL_SMTP_PASSWORD)")
+	mailListen := flag.String("mail-listen", "", "accept mail for the email gateway on this address, e.g. :2525 (disabled when empty)")
+	mailAddress := flag.String("mail-address", "log@hal", "gateway address; crew post by mailing log+<token>@hal")
+	syslogListen := flag.String("syslog-listen", "", "receive syslog over UDP and TCP on this address, e.g. :5514 (disabled when empty)")
+	syslogUser := flag.String("syslog-user", "SYSLOG", "user that syslog entries are posted as")
+	syslogMinSeverity := flag.String("syslog-min-severity", "notice", "least severe syslog level that is posted (emerg ... debug)")
+	syslogRate := flag.Int("syslog-rate", 60, "syslog messages posted per host and minute (0 disables the limit)")
+	syslogExclude := flag.String("syslog-exclude", "", "drop syslog messages matching this regular expression")
+	syslogIgnoreHosts := flag.String("syslog-ignore-hosts", "", "comma-separated hosts whose syslog messages are dropped")
+	ircListen := flag.String("irc-listen", "", "accept IRC clients on this address, e.g. :6667 (disabled when empty)")
+	ircName := flag.String("irc-name", "hal", "server name the IRC gateway introduces itself with")
+	digests := flag.Bool("digests", false, "let users subscribe to daily or weekly email digests")
+	digestHour := flag.Int("digest-hour", 8, "local hour at which digests for the previous day or week are sent")
 	flag.Parse()
 
-	db := Must(sql.Open("sqlite3", "./worklog.db"))
+	var mailer *Mailer
+	if *smtpAddr != "" {
+		mailer = &Mailer{
+			Addr:     *smtpAddr,
+			From:     *smtpFrom,
+			Username: *smtpUser,
+			Password: *smtpPassword,
+		}
+	}
 
-	Must(db.Exec(CreateUsersTableQuery))
-	Must(db.Exec(CreateTableQuery))
+	var alertSinks []AlertSink
+	for _, url := range splitList(*alertWebhooks) {
+		alertSinks = append(alertSinks, NewWebhookAlertSink(url))
+	}
+	var escalationSinks []AlertSink
+	for _, url := range splitList(*escalationWebhooks) {
+		escalationSinks = append(escalationSinks, NewWebhookAlertSink(url))
+	}
 
-	s := NewServer(db)
+	if to := splitList(*alertEmails); len(to) > 0 {
+		if mailer == nil {
+			log.Fatal("-alert-emails requires -smtp-addr")
+		}
+		alertSinks = append(alertSinks, &EmailAlertSink{Mailer: mailer, To: to})
+	}
+
+	if *digests {
+		if mailer == nil || *publicURL == "" {
+			log.Fatal("-digests requires -smtp-addr and -public-url")
+		}
+		if *digestHour < 0 || *digestHour > 23 {
+			log.Fatal("-digest-hour must be between 0 and 23")
+		}
+	}
+
+	db := openDB(*dbPath)
+
+	s := NewServer(db, Config{
+		StripHashtags: *stripHashtags,
+		TagPipeline:   Must(ParseTagPipeline(*tagPipeline)),
+		TagAllowList:  *tagAllowList,
+		AdminToken:    *adminToken,
+		AlertSinks:    alertSinks,
+
+		EscalationWindow: *escalationWindow,
+		EscalationMax:    *escalationMax,
+		EscalationSinks:  escalationSinks,
+
+		WebhookMaxAttempts: *webhookMaxAttempts,
+		WebhookBackoff:     *webhookBackoff,
+
+		IdempotencyTTL: *idempotencyTTL,
+
+		PublicURL:   *publicURL,
+		TemplateDir: *templateDir,
+
+		Mailer:     mailer,
+		Digests:    *digests,
+		DigestHour: *digestHour,
+	})
 
 	mux := http.NewServeMux()
 
@@ -41,13 +184,91 @@ func main() {
 
 	mux.HandleFunc("POST /users", s.handleCreateUser)
 
+	mux.HandleFunc("GET /tags", s.handleTags)
+	mux.HandleFunc("GET /tags/{tag}/timeline", s.handleTagTimeline)
+	mux.HandleFunc("GET /taxonomy", s.handleTaxonomy)
+	mux.HandleFunc("POST /admin/tags", s.handleCreateTag)
+	mux.HandleFunc("DELETE /admin/tags/{tag}", s.handleDeleteTag)
+	mux.HandleFunc("POST /admin/tags/{tag}/aliases", s.handleCreateAlias)
+	mux.HandleFunc("POST /admin/tags/{tag}/rename", s.handleRenameTag)
+	mux.HandleFunc("POST /admin/tags/merge", s.handleMergeTags)
+	mux.HandleFunc("DELETE /admin/aliases/{alias}", s.handleDeleteAlias)
+
+	mux.HandleFunc("POST /admin/webhooks", s.handleCreateWebhook)
+	mux.HandleFunc("GET /admin/webhooks", s.handleWebhooks)
+	mux.HandleFunc("DELETE /admin/webhooks/{id}", s.handleDeleteWebhook)
+	mux.HandleFunc("GET /admin/webhooks/{id}/deliveries", s.handleWebhookDeliveries)
+	mux.HandleFunc("GET /admin/webhooks/dead-letters", s.handleDeadLetters)
+	mux.HandleFunc("POST /admin/webhooks/deliveries/{id}/retry", s.handleRetryDelivery)
+
+	mux.HandleFunc("POST /admin/integrations", s.handleCreateIntegration)
+	mux.HandleFunc("GET /admin/integrations", s.handleIntegrations)
+	mux.HandleFunc("DELETE /admin/integrations/{id}", s.handleDeleteIntegration)
+	mux.HandleFunc("POST /hooks/slack/{secret}", s.handleSlackHook)
+
+	mux.HandleFunc("POST /admin/import", s.handleImport)
+	mux.HandleFunc("GET /export", s.handleExport)
+	mux.HandleFunc("GET /reports/{period}", s.handleReport)
+	mux.HandleFunc("GET /digest", s.handleDigestSubscription)
+	mux.HandleFunc("PUT /digest", s.handleSubscribeDigest)
+	mux.HandleFunc("DELETE /digest", s.handleDeleteDigestSubscription)
+	mux.HandleFunc("POST /digest/send", s.handleSendDigest)
+	mux.HandleFunc("GET /digest/unsubscribe/{token}", s.handleUnsubscribePage)
+	mux.HandleFunc("POST /digest/unsubscribe/{token}", s.handleUnsubscribeDigest)
+	mux.HandleFunc("GET /keys", s.handleSSHKeys)
+	mux.HandleFunc("POST /keys", s.handleAddSSHKey)
+	mux.HandleFunc("DELETE /keys/{id}", s.handleDeleteSSHKey)
+	mux.HandleFunc("POST /admin/ssh/authorize", s.handleAuthorizeSSHKey)
+	mux.HandleFunc("GET /feeds/{file}", s.handleFeed)
+	mux.HandleFunc("GET /feeds/user/{file}", s.handleUserFeed)
+	mux.HandleFunc("GET /feeds/tag/{file}", s.handleTagFeed)
+
 	mux.HandleFunc("/initial/", s.handleInitial)
 	mux.HandleFunc("/initial", s.handleInitial)
 	mux.HandleFunc("/stream", s.handleStream)
-	mux.HandleFunc("/update", s.handlePost)
+	mux.HandleFunc("GET /stream.txt", s.handleTextStream)
+	mux.HandleFunc("/update", s.idempotent(s.handlePost))
+	mux.HandleFunc("POST /update/batch", s.idempotent(s.handleBatchPost))
+	mux.HandleFunc("POST /standup", s.handlePostStandup)
+	mux.HandleFunc("GET /standup", s.handleStandupSummary)
+	mux.HandleFunc("GET /blockers", s.handleBlockers)
+	mux.HandleFunc("POST /blockers/{id}/resolve", s.handleResolveBlocker)
+	mux.HandleFunc("POST /entries/{id}/ack", s.handleAckEntry)
+	mux.HandleFunc("GET /entries/{id}/escalations", s.handleEscalations)
 	mux.HandleFunc("GET /user/{username}", s.handleUserIndex)
+	mux.HandleFunc("GET /entry/{id}", s.handleEntryPage)
+	mux.HandleFunc("GET /search", s.handleSearch)
 	mux.HandleFunc("/", s.handleIndex)
 
+	if *mailListen != "" {
+		gateway := Must(NewMailGateway(s, *mailAddress))
+		ln := Must(net.Listen("tcp", *mailListen))
+		go gateway.Serve(ln) // nolint:errcheck
+		log.Printf("Accepting mail for %s+<token>@%s on %s", gateway.Local, gateway.Domain, *mailListen)
+	}
+
+	if *syslogListen != "" {
+		cfg := SyslogConfig{
+			Username:    *syslogUser,
+			MinSeverity: Must(ParseSyslogSeverity(*syslogMinSeverity)),
+			RateLimit:   *syslogRate,
+			IgnoreHosts: splitList(*syslogIgnoreHosts),
+		}
+		if *syslogExclude != "" {
+			cfg.Exclude = Must(regexp.Compile(*syslogExclude))
+		}
+		receiver := Must(NewSyslogReceiver(s, cfg))
+		go receiver.ServeUDP(Must(net.ListenPacket("udp", *syslogListen))) // nolint:errcheck
+		go receiver.ServeTCP(Must(net.Listen("tcp", *syslogListen)))       // nolint:errcheck
+		log.Printf("Receiving syslog on %s (udp, tcp) as %s", *syslogListen, strings.ToUpper(*syslogUser))
+	}
+
+	if *ircListen != "" {
+		gateway := NewIRCGateway(s, *ircName)
+		go gateway.Serve(Must(net.Listen("tcp", *ircListen))) // nolint:errcheck
+		log.Printf("Accepting IRC clients on %s, join %s", *ircListen, ircGlobalChannel)
+	}
+
 	srv := &http.Server{
 		Addr:    *addr,
 		Handler: mux,
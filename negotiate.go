@@ -0,0 +1,193 @@
+package main
+
+import (
+	"cmp"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Media types offered by the content-negotiated endpoints.
+const (
+	mediaJSON     = "application/json"
+	mediaHTML     = "text/html"
+	mediaText     = "text/plain"
+	mediaMarkdown = "text/markdown"
+)
+
+// entryFormats are the representations of an entry list chosen through the
+// Accept header. HTML is rendered from templates instead.
+var entryFormats = map[string]exportFormat{
+	mediaJSON:     exportFormats["json"],
+	mediaText:     {"text/plain; charset=utf-8", false, newTextEntryWriter},
+	mediaMarkdown: exportFormats["md"],
+}
+
+// negotiate picks the offer the client prefers according to its Accept
+// header. Ties go to the earlier offer, and a missing header selects the
+// first one. It returns "" if the client accepts none of the offers.
+func negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality the Accept header gives a media type,
+// taken from the most specific range matching it.
+func acceptQuality(accept, offer string) float64 {
+	offerType, _, _ := strings.Cut(offer, "/")
+
+	// specificity is 0 for */*, 1 for type/* and 2 for an exact match.
+	q, specificity := 0.0, -1
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		s := -1
+		switch {
+		case mediaType == offer:
+			s = 2
+		case mediaType == offerType+"/*":
+			s = 1
+		case mediaType == "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+
+		rangeQ := 1.0
+		if v, ok := params["q"]; ok {
+			if rangeQ, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		q, specificity = rangeQ, s
+	}
+	return q
+}
+
+// feedPage is the data of the server-rendered feed template.
+type feedPage struct {
+	Title   string
+	Heading string
+	// User limits the live updates of the page to one crew member.
+	User    string
+	Entries []Update
+
+	// Boot plays the boot sequence before showing the page, and Live keeps
+	// it updated from the stream. Both need JavaScript.
+	Boot bool
+	Live bool
+
+	// Search is set on search result pages.
+	Search *searchForm
+}
+
+// writeEntries sends a list of entries, in the order they were posted, in
+// the representation negotiated from offers. HTML shows the newest first,
+// like the web UI.
+func (s *Server) writeEntries(w http.ResponseWriter, r *http.Request, page feedPage, offers ...string) {
+	w.Header().Add("Vary", "Accept")
+
+	mediaType := negotiate(r, offers...)
+	if mediaType == "" {
+		http.Error(w, "not acceptable, try one of: "+strings.Join(offers, ", "), http.StatusNotAcceptable)
+		return
+	}
+
+	if mediaType == mediaHTML {
+		slices.Reverse(page.Entries)
+		s.renderPage(w, "feed.html", page)
+		return
+	}
+
+	format := entryFormats[mediaType]
+	if format.byDay {
+		slices.SortStableFunc(page.Entries, func(a, b Update) int {
+			return cmp.Or(
+				strings.Compare(dayOf(a.Timestamp), dayOf(b.Timestamp)),
+				strings.Compare(a.Username, b.Username),
+			)
+		})
+	}
+
+	w.Header().Set("Content-Type", format.contentType)
+	ew := format.newWriter(w)
+	for _, u := range page.Entries {
+		if err := ew.WriteEntry(u); err != nil {
+			log.Printf("write entries: %v", err)
+			return
+		}
+	}
+	ew.Close() // nolint:errcheck
+}
+
+// dayOf returns the date part of a stored timestamp.
+func dayOf(ts string) string {
+	if len(ts) < len(dateLayout) {
+		return ts
+	}
+	return ts[:len(dateLayout)]
+}
+
+// textEntryWriter writes entries as an aligned plain-text table, one line
+// per entry.
+type textEntryWriter struct {
+	tw     *tabwriter.Writer
+	header bool
+}
+
+func newTextEntryWriter(w io.Writer) entryWriter {
+	return &textEntryWriter{tw: tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)}
+}
+
+func (tw *textEntryWriter) writeHeader() error {
+	if tw.header {
+		return nil
+	}
+	tw.header = true
+	_, err := io.WriteString(tw.tw, "ID\tTIME\tUSER\tSEVERITY\tMESSAGE\tTAGS\n")
+	return err
+}
+
+func (tw *textEntryWriter) WriteEntry(u Update) error {
+	if err := tw.writeHeader(); err != nil {
+		return err
+	}
+
+	// Cells must stay on one line and free of tabs to keep the table aligned.
+	message := strings.Join(strings.Fields(sanitizeText(u.Message)), " ")
+	_, err := io.WriteString(tw.tw, strings.Join([]string{
+		strconv.FormatInt(u.ID, 10),
+		formatTime(u.Timestamp),
+		sanitizeText(u.Username),
+		u.Severity,
+		message,
+		sanitizeText(strings.Join(u.Tags, ",")),
+	}, "\t")+"\n")
+	return err
+}
+
+func (tw *textEntryWriter) Close() error {
+	if err := tw.writeHeader(); err != nil {
+		return err
+	}
+	return tw.tw.Flush()
+}
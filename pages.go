@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// searchLimit is the number of entries a search returns at most.
+const searchLimit = 200
+
+// searchForm holds the search parameters, echoed back into the search form.
+type searchForm struct {
+	Query string
+	User  string
+	Tag   string
+	From  string
+	To    string
+
+	// Searched is false until any parameter is given, so the bare page
+	// shows only the form.
+	Searched bool
+	Limit    int
+}
+
+// permalinkPage is the data of the single entry template.
+type permalinkPage struct {
+	Title string
+	Entry Update
+}
+
+// handleEntryPage serves the permalink of an entry: a page with the entry,
+// its acknowledgement and escalation chain, or the entry as JSON.
+func (s *Server) handleEntryPage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	u, err := s.getUpdate(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "entry not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	u.Escalations, err = s.getEscalations(id)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	switch negotiate(r, mediaHTML, mediaJSON) {
+	case mediaHTML:
+		s.renderPage(w, "permalink.html", permalinkPage{
+			Title: "HAL 9000 // entry " + strconv.FormatInt(u.ID, 10),
+			Entry: u,
+		})
+	case mediaJSON:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u) // nolint:errcheck
+	default:
+		http.Error(w, "not acceptable, try one of: text/html, application/json", http.StatusNotAcceptable)
+	}
+}
+
+// handleSearch finds the latest entries whose message contains q, filtered
+// like /export by from, to, user and tag. Results are negotiated like
+// /initial, with the HTML page showing a search form above them.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	filter, err := s.parseEntryFilter(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	form := &searchForm{
+		Query: strings.TrimSpace(query.Get("q")),
+		User:  query.Get("user"),
+		Tag:   query.Get("tag"),
+		From:  query.Get("from"),
+		To:    query.Get("to"),
+		Limit: searchLimit,
+	}
+	form.Searched = form.Query != "" || form.User != "" || form.Tag != "" || form.From != "" || form.To != ""
+
+	list := []Update{}
+	if form.Searched {
+		args := append(filter.args(), form.Query, form.Query, searchLimit)
+		rows, err := s.db.Query(SearchEntriesQuery, args...)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close() // nolint:errcheck
+
+		for rows.Next() {
+			u, err := scanUpdate(rows)
+			if err != nil {
+				continue
+			}
+			list = append(list, u)
+		}
+		// The newest entries were selected; list them in the order they were posted.
+		slices.Reverse(list)
+	}
+
+	s.writeEntries(w, r, feedPage{
+		Title:   "HAL 9000 // search",
+		Heading: "COMMS Archive: SEARCH",
+		Entries: list,
+		Search:  form,
+	}, mediaHTML, mediaJSON, mediaText, mediaMarkdown)
+}
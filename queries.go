@@ -34,9 +34,88 @@ var CreateTableQuery string = `
 	)
 `
 
+var AddUserKindColumnQuery string = `
+	ALTER TABLE users ADD COLUMN kind TEXT NOT NULL DEFAULT 'crew'
+`
+
+var AddEntryKindColumnQuery string = `
+	ALTER TABLE log_entries ADD COLUMN kind TEXT NOT NULL DEFAULT 'log'
+`
+
+var AddEntryStatusColumnQuery string = `
+	ALTER TABLE log_entries ADD COLUMN status TEXT NOT NULL DEFAULT ''
+`
+
+var AddEntryResolutionColumnQuery string = `
+	ALTER TABLE log_entries ADD COLUMN resolution TEXT NOT NULL DEFAULT ''
+`
+
+var AddEntryResolvedAtColumnQuery string = `
+	ALTER TABLE log_entries ADD COLUMN resolved_at TEXT NOT NULL DEFAULT ''
+`
+
+var AddEntryResolvedByColumnQuery string = `
+	ALTER TABLE log_entries ADD COLUMN resolved_by INTEGER REFERENCES users (id)
+`
+
+var AddEntrySeverityColumnQuery string = `
+	ALTER TABLE log_entries ADD COLUMN severity TEXT NOT NULL DEFAULT 'INFO'
+`
+
+var AddEntryAckedAtColumnQuery string = `
+	ALTER TABLE log_entries ADD COLUMN acked_at TEXT NOT NULL DEFAULT ''
+`
+
+var AddEntryAckedByColumnQuery string = `
+	ALTER TABLE log_entries ADD COLUMN acked_by INTEGER REFERENCES users (id)
+`
+
+var AddEntryEscalationLevelColumnQuery string = `
+	ALTER TABLE log_entries ADD COLUMN escalation_level INTEGER NOT NULL DEFAULT 0
+`
+
+var AddEntryEscalatedAtColumnQuery string = `
+	ALTER TABLE log_entries ADD COLUMN escalated_at TEXT NOT NULL DEFAULT ''
+`
+
+var CreateEscalationsTableQuery string = `
+	CREATE TABLE IF NOT EXISTS escalations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		entry_id INTEGER NOT NULL,
+		level INTEGER NOT NULL,
+		notified TEXT NOT NULL,
+		ts TEXT NOT NULL,
+		FOREIGN KEY (entry_id) REFERENCES log_entries (id)
+	)
+`
+
+var CreateStandupsTableQuery string = `
+	CREATE TABLE IF NOT EXISTS standups (
+		entry_id INTEGER PRIMARY KEY,
+		yesterday TEXT NOT NULL DEFAULT '',
+		today TEXT NOT NULL DEFAULT '',
+		blockers TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (entry_id) REFERENCES log_entries (id)
+	)
+`
+
+var CreateTagsTableQuery string = `
+	CREATE TABLE IF NOT EXISTS tags (
+		name TEXT PRIMARY KEY,
+		created_at TEXT NOT NULL
+	)
+`
+
+var CreateTagAliasesTableQuery string = `
+	CREATE TABLE IF NOT EXISTS tag_aliases (
+		alias TEXT PRIMARY KEY,
+		tag TEXT NOT NULL
+	)
+`
+
 var CreateUserQuery string = `
-	INSERT INTO users (username, token, created_at)
-	VALUES (?, ?, ?)
+	INSERT INTO users (username, token, created_at, kind)
+	VALUES (?, ?, ?, ?)
 `
 
 var GetUserByTokenQuery string = `
@@ -48,24 +127,500 @@ var GetUserByUsernameQuery string = `
 `
 
 var InsertEntryQuery string = `
-	INSERT INTO log_entries (user_id, message, tags, ts)
+	INSERT INTO log_entries (user_id, message, tags, ts, kind, status, severity)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+`
+
+var InsertStandupQuery string = `
+	INSERT INTO standups (entry_id, yesterday, today, blockers)
 	VALUES (?, ?, ?, ?)
 `
 
-var SelectTodayEntriesQuery string = `
-	SELECT le.id, u.username, le.message, le.tags, le.ts
+// SelectCrewUsernamesQuery lists the crew members who were around on a
+// day: registered by then, or with entries from before it, as imported
+// users have. System and integration accounts are left out.
+var SelectCrewUsernamesQuery string = `
+	SELECT u.username
+	FROM users u
+	WHERE u.kind = 'crew'
+		AND (substr(u.created_at, 1, 10) <= ? OR EXISTS (
+			SELECT 1 FROM log_entries le
+			WHERE le.user_id = u.id AND substr(le.ts, 1, 10) <= ?
+		))
+	ORDER BY u.username ASC
+`
+
+// entrySelect is the column list and joins shared by every query that returns
+// full entries. scanUpdate reads the columns in this order.
+const entrySelect = `
+	SELECT le.id, u.username, le.message, le.tags, le.ts, le.kind,
+		st.yesterday, st.today, st.blockers,
+		le.status, le.resolution, le.resolved_at, ru.username,
+		le.severity, le.acked_at, au.username, le.escalation_level
 	FROM log_entries le
 	LEFT JOIN users u ON le.user_id = u.id
+	LEFT JOIN standups st ON st.entry_id = le.id
+	LEFT JOIN users ru ON le.resolved_by = ru.id
+	LEFT JOIN users au ON le.acked_by = au.id
+`
+
+// severityRankExpr ranks le.severity the same way as severityRanks.
+const severityRankExpr = `
+	CASE le.severity WHEN 'CRIT' THEN 2 WHEN 'WARN' THEN 1 ELSE 0 END
+`
+
+var SelectEntryByIDQuery string = entrySelect + `
+	WHERE le.id = ?
+`
+
+var SelectTodayEntriesQuery string = entrySelect + `
 	WHERE substr(le.ts, 1, 10) = date('now', 'localtime')
+		AND ` + severityRankExpr + ` >= ?
 	ORDER BY le.ts ASC
 	LIMIT 500
 `
 
-var SelectTodayEntriesByUserQuery string = `
-	SELECT le.id, u.username, le.message, le.tags, le.ts
-	FROM log_entries le
-	JOIN users u ON le.user_id = u.id
+var SelectTodayEntriesByUserQuery string = entrySelect + `
 	WHERE u.username = ? AND substr(le.ts, 1, 10) = date('now', 'localtime')
+		AND ` + severityRankExpr + ` >= ?
 	ORDER BY le.ts ASC
 	LIMIT 500
 `
+
+var ResolveTagQuery string = `
+	SELECT name FROM tags WHERE name = ?1
+	UNION ALL
+	SELECT tag FROM tag_aliases WHERE alias = ?1
+	LIMIT 1
+`
+
+var SelectTaxonomyQuery string = `
+	SELECT t.name, group_concat(a.alias)
+	FROM tags t
+	LEFT JOIN tag_aliases a ON a.tag = t.name
+	GROUP BY t.name
+	ORDER BY t.name ASC
+`
+
+var TagExistsQuery string = `
+	SELECT 1 FROM tags WHERE name = ?
+`
+
+var InsertTagQuery string = `
+	INSERT INTO tags (name, created_at)
+	VALUES (?, datetime('now', 'localtime'))
+`
+
+var InsertTagIgnoreQuery string = `
+	INSERT OR IGNORE INTO tags (name, created_at)
+	VALUES (?, datetime('now', 'localtime'))
+`
+
+var DeleteTagQuery string = `
+	DELETE FROM tags WHERE name = ?
+`
+
+var UpsertTagAliasQuery string = `
+	INSERT INTO tag_aliases (alias, tag)
+	VALUES (?, ?)
+	ON CONFLICT (alias) DO UPDATE SET tag = excluded.tag
+`
+
+var DeleteTagAliasQuery string = `
+	DELETE FROM tag_aliases WHERE alias = ?
+`
+
+var DeleteAliasesForTagQuery string = `
+	DELETE FROM tag_aliases WHERE tag = ?
+`
+
+var RepointTagAliasesQuery string = `
+	UPDATE tag_aliases SET tag = ? WHERE tag = ?
+`
+
+var SelectEntriesWithTagQuery string = `
+	SELECT id, tags FROM log_entries
+	WHERE instr(',' || tags || ',', ',' || ? || ',') > 0
+`
+
+var UpdateEntryTagsQuery string = `
+	UPDATE log_entries SET tags = ? WHERE id = ?
+`
+
+var SelectTaggedEntriesInRangeQuery string = `
+	SELECT u.username, le.tags, le.ts
+	FROM log_entries le
+	LEFT JOIN users u ON le.user_id = u.id
+	WHERE le.tags != '' AND substr(le.ts, 1, 10) BETWEEN ? AND ?
+	ORDER BY le.ts ASC
+`
+
+var SelectTagTimelineQuery string = `
+	SELECT substr(ts, 1, 10) AS day, count(*)
+	FROM log_entries
+	WHERE instr(',' || tags || ',', ',' || ? || ',') > 0
+		AND substr(ts, 1, 10) BETWEEN ? AND ?
+	GROUP BY day
+	ORDER BY day ASC
+`
+
+// entryRangeFilter limits entries to a range of days and, optionally, to a
+// user and a tag. An empty user or tag parameter matches every entry.
+const entryRangeFilter = `
+	WHERE substr(le.ts, 1, 10) BETWEEN ? AND ?
+		AND (? = '' OR u.username = ?)
+		AND (? = '' OR instr(',' || le.tags || ',', ',' || ? || ',') > 0)
+`
+
+var SelectEntriesInRangeQuery string = entrySelect + entryRangeFilter + `
+	ORDER BY le.ts ASC, le.id ASC
+`
+
+var SelectEntriesInRangeByDayQuery string = entrySelect + entryRangeFilter + `
+	ORDER BY substr(le.ts, 1, 10) ASC, u.username ASC, le.ts ASC, le.id ASC
+`
+
+// SearchEntriesQuery finds the latest entries in a range whose message
+// contains a text, ignoring case. An empty text matches every entry.
+var SearchEntriesQuery string = entrySelect + entryRangeFilter + `
+		AND (? = '' OR instr(lower(le.message), lower(?)) > 0)
+	ORDER BY le.ts DESC, le.id DESC
+	LIMIT ?
+`
+
+var SelectLatestEntriesQuery string = entrySelect + `
+	WHERE (? = '' OR u.username = ?)
+		AND (? = '' OR instr(',' || le.tags || ',', ',' || ? || ',') > 0)
+	ORDER BY le.ts DESC, le.id DESC
+	LIMIT ?
+`
+
+var SelectStandupsForDayQuery string = entrySelect + `
+	WHERE le.kind = 'standup' AND substr(le.ts, 1, 10) = ?
+	ORDER BY le.ts ASC
+`
+
+var SelectBlockersQuery string = entrySelect + `
+	WHERE le.status = ?
+	ORDER BY le.ts ASC
+`
+
+// SelectCarriedBlockersQuery returns the blockers opened before a range
+// that were still open when it started, optionally for a user and a tag.
+var SelectCarriedBlockersQuery string = entrySelect + `
+	WHERE le.status != '' AND substr(le.ts, 1, 10) < ?
+		AND (le.status = 'open' OR substr(le.resolved_at, 1, 10) >= ?)
+		AND (? = '' OR u.username = ?)
+		AND (? = '' OR instr(',' || le.tags || ',', ',' || ? || ',') > 0)
+	ORDER BY le.ts ASC
+`
+
+var ResolveBlockerQuery string = `
+	UPDATE log_entries
+	SET status = 'resolved', resolution = ?, resolved_at = ?, resolved_by = ?
+	WHERE id = ? AND status = 'open'
+`
+
+var AckEntryQuery string = `
+	UPDATE log_entries SET acked_at = ?, acked_by = ?
+	WHERE id = ? AND acked_at = ''
+`
+
+var SelectUnackedCritEntriesQuery string = `
+	SELECT id, ts, escalated_at, escalation_level
+	FROM log_entries
+	WHERE severity = 'CRIT' AND acked_at = '' AND escalation_level < ?
+`
+
+var EscalateEntryQuery string = `
+	UPDATE log_entries SET escalation_level = ?, escalated_at = ?
+	WHERE id = ? AND acked_at = '' AND escalation_level = ?
+`
+
+var InsertEscalationQuery string = `
+	INSERT INTO escalations (entry_id, level, notified, ts)
+	VALUES (?, ?, ?, ?)
+`
+
+var SelectEscalationsQuery string = `
+	SELECT level, notified, ts FROM escalations
+	WHERE entry_id = ?
+	ORDER BY level ASC
+`
+
+var CreateWebhooksTableQuery string = `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		events TEXT NOT NULL DEFAULT '',
+		tags TEXT NOT NULL DEFAULT '',
+		users TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	)
+`
+
+var CreateWebhookDeliveriesTableQuery string = `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt INTEGER NOT NULL,
+		response_code INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		FOREIGN KEY (webhook_id) REFERENCES webhooks (id)
+	)
+`
+
+var InsertWebhookQuery string = `
+	INSERT INTO webhooks (url, events, tags, users, secret, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+`
+
+var SelectWebhooksQuery string = `
+	SELECT id, url, events, tags, users, secret, created_at
+	FROM webhooks
+	ORDER BY id ASC
+`
+
+var SelectWebhookQuery string = `
+	SELECT id, url, events, tags, users, secret, created_at
+	FROM webhooks
+	WHERE id = ?
+`
+
+var DeleteWebhookQuery string = `
+	DELETE FROM webhooks WHERE id = ?
+`
+
+var DeleteWebhookDeliveriesQuery string = `
+	DELETE FROM webhook_deliveries WHERE webhook_id = ?
+`
+
+var InsertWebhookDeliveryQuery string = `
+	INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+`
+
+// webhookDeliverySelect is the column list read by scanWebhookDelivery.
+const webhookDeliverySelect = `
+	SELECT d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts,
+		d.next_attempt, d.response_code, d.last_error, d.created_at, d.updated_at
+	FROM webhook_deliveries d
+`
+
+// SelectDueWebhookDeliveriesQuery returns the oldest pending deliveries of
+// every webhook, at most 10 each, so a webhook with a long backlog cannot
+// starve the others. Deliveries are returned in the order they were queued
+// and stop at the first one waiting for a retry, so every webhook receives
+// its events in order.
+var SelectDueWebhookDeliveriesQuery string = webhookDeliverySelect + `
+	WHERE d.id IN (
+		SELECT id FROM (
+			SELECT id,
+				ROW_NUMBER() OVER (PARTITION BY webhook_id ORDER BY id) AS n,
+				MIN(CASE WHEN next_attempt > ? THEN id END) OVER (PARTITION BY webhook_id) AS waiting
+			FROM webhook_deliveries
+			WHERE status = 'pending'
+		)
+		WHERE n <= 10 AND (waiting IS NULL OR id < waiting)
+	)
+	ORDER BY d.webhook_id ASC, d.id ASC
+`
+
+var SelectWebhookDeliveriesQuery string = webhookDeliverySelect + `
+	WHERE d.webhook_id = ?
+	ORDER BY d.id DESC
+	LIMIT 200
+`
+
+var SelectDeadWebhookDeliveriesQuery string = webhookDeliverySelect + `
+	WHERE d.status = 'dead'
+	ORDER BY d.id DESC
+	LIMIT 200
+`
+
+var UpdateWebhookDeliveryQuery string = `
+	UPDATE webhook_deliveries
+	SET status = ?, attempts = ?, next_attempt = ?, response_code = ?, last_error = ?, updated_at = ?
+	WHERE id = ?
+`
+
+var RetryWebhookDeliveryQuery string = `
+	UPDATE webhook_deliveries
+	SET status = 'pending', attempts = 0, next_attempt = ?, last_error = '', updated_at = ?
+	WHERE id = ? AND status = 'dead'
+`
+
+var CreateIntegrationsTableQuery string = `
+	CREATE TABLE IF NOT EXISTS integrations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		secret TEXT UNIQUE NOT NULL,
+		user_id INTEGER NOT NULL,
+		tags TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	)
+`
+
+var InsertIntegrationQuery string = `
+	INSERT INTO integrations (name, secret, user_id, tags, created_at)
+	VALUES (?, ?, ?, ?, ?)
+`
+
+// integrationSelect is the column list read by scanIntegration.
+const integrationSelect = `
+	SELECT i.id, i.name, i.secret, u.id, u.username, i.tags, i.created_at
+	FROM integrations i
+	JOIN users u ON i.user_id = u.id
+`
+
+var SelectIntegrationsQuery string = integrationSelect + `
+	ORDER BY i.name ASC
+`
+
+var SelectIntegrationBySecretQuery string = integrationSelect + `
+	WHERE i.secret = ?
+`
+
+var DeleteIntegrationQuery string = `
+	DELETE FROM integrations WHERE id = ?
+`
+
+var CreateIdempotencyKeysTableQuery string = `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT NOT NULL DEFAULT '',
+		response TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, key),
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	)
+`
+
+var DeleteExpiredIdempotencyKeysQuery string = `
+	DELETE FROM idempotency_keys WHERE created_at < ?
+`
+
+var ReserveIdempotencyKeyQuery string = `
+	INSERT INTO idempotency_keys (user_id, key, request_hash, created_at)
+	VALUES (?, ?, ?, ?)
+`
+
+var SelectIdempotencyKeyQuery string = `
+	SELECT request_hash, status_code, content_type, response
+	FROM idempotency_keys
+	WHERE user_id = ? AND key = ?
+`
+
+var CompleteIdempotencyKeyQuery string = `
+	UPDATE idempotency_keys SET status_code = ?, content_type = ?, response = ?
+	WHERE user_id = ? AND key = ?
+`
+
+var ReleaseIdempotencyKeyQuery string = `
+	DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?
+`
+
+var CreateDigestSubscriptionsTableQuery string = `
+	CREATE TABLE IF NOT EXISTS digest_subscriptions (
+		user_id INTEGER PRIMARY KEY,
+		email TEXT NOT NULL,
+		frequency TEXT NOT NULL,
+		unsubscribe_token TEXT UNIQUE NOT NULL,
+		last_period TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	)
+`
+
+// digestSubscriptionSelect is the column list read by scanDigestSubscription.
+const digestSubscriptionSelect = `
+	SELECT ds.user_id, u.username, ds.email, ds.frequency, ds.unsubscribe_token,
+		ds.last_period, ds.created_at
+	FROM digest_subscriptions ds
+	JOIN users u ON ds.user_id = u.id
+`
+
+var UpsertDigestSubscriptionQuery string = `
+	INSERT INTO digest_subscriptions (user_id, email, frequency, unsubscribe_token, last_period, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (user_id) DO UPDATE SET
+		email = excluded.email,
+		frequency = excluded.frequency,
+		last_period = excluded.last_period
+`
+
+var SelectDigestSubscriptionQuery string = digestSubscriptionSelect + `
+	WHERE ds.user_id = ?
+`
+
+var SelectDigestSubscriptionByTokenQuery string = digestSubscriptionSelect + `
+	WHERE ds.unsubscribe_token = ?
+`
+
+var SelectDigestSubscriptionsQuery string = digestSubscriptionSelect + `
+	ORDER BY ds.user_id ASC
+`
+
+var UpdateDigestLastPeriodQuery string = `
+	UPDATE digest_subscriptions SET last_period = ? WHERE user_id = ?
+`
+
+var DeleteDigestSubscriptionQuery string = `
+	DELETE FROM digest_subscriptions WHERE user_id = ?
+`
+
+var UnsubscribeDigestQuery string = `
+	DELETE FROM digest_subscriptions WHERE unsubscribe_token = ?
+`
+
+var CreateSSHKeysTableQuery string = `
+	CREATE TABLE IF NOT EXISTS ssh_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		key_type TEXT NOT NULL,
+		fingerprint TEXT UNIQUE NOT NULL,
+		public_key TEXT NOT NULL,
+		comment TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	)
+`
+
+// sshKeySelect is the column list read by scanSSHKey.
+const sshKeySelect = `
+	SELECT k.id, u.username, k.key_type, k.fingerprint, k.public_key, k.comment, k.created_at
+	FROM ssh_keys k
+	JOIN users u ON k.user_id = u.id
+`
+
+var InsertSSHKeyQuery string = `
+	INSERT INTO ssh_keys (user_id, key_type, fingerprint, public_key, comment, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+`
+
+var SelectSSHKeysByUserQuery string = sshKeySelect + `
+	WHERE k.user_id = ?
+	ORDER BY k.id ASC
+`
+
+var DeleteSSHKeyQuery string = `
+	DELETE FROM ssh_keys WHERE id = ? AND user_id = ?
+`
+
+var SelectUserBySSHKeyQuery string = `
+	SELECT u.id, u.username, u.token
+	FROM ssh_keys k
+	JOIN users u ON k.user_id = u.id
+	WHERE k.fingerprint = ?
+`
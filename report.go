@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report periods.
+const (
+	ReportDaily  = "daily"
+	ReportWeekly = "weekly"
+)
+
+// reportTopTags is the number of tags listed as top tags in a report.
+const reportTopTags = 5
+
+// ReportCount is a named count, such as the number of entries with a tag.
+type ReportCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// ReportGroup is the entries of a report posted by one user or carrying one tag.
+type ReportGroup struct {
+	Name    string   `json:"name"`
+	Count   int      `json:"count"`
+	Entries []Update `json:"entries"`
+}
+
+// Report is a digest of the entries posted over a day or a week.
+type Report struct {
+	Period      string         `json:"period"`
+	From        string         `json:"from"`
+	To          string         `json:"to"`
+	GeneratedAt string         `json:"generated_at"`
+	Total       int            `json:"total"`
+	Severities  map[string]int `json:"severities"`
+	TopTags     []ReportCount  `json:"top_tags"`
+	Users       []ReportGroup  `json:"users"`
+	Tags        []ReportGroup  `json:"tags"`
+	Blockers    []Blocker      `json:"blockers"`
+}
+
+// parseReportPeriod accepts daily/weekly and their day/week shorthands.
+func parseReportPeriod(v string) (string, error) {
+	switch strings.ToLower(v) {
+	case "daily", "day":
+		return ReportDaily, nil
+	case "weekly", "week":
+		return ReportWeekly, nil
+	}
+	return "", fmt.Errorf("unknown report period %q", v)
+}
+
+// reportRange returns the first and last day of the period containing day.
+// Weeks start on Monday.
+func reportRange(period string, day time.Time) (time.Time, time.Time) {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	if period == ReportDaily {
+		return day, day
+	}
+	offset := (int(day.Weekday()) + 6) % 7
+	from := day.AddDate(0, 0, -offset)
+	return from, from.AddDate(0, 0, 6)
+}
+
+// groupEntries adds an entry to the group with the given name.
+func groupEntries(groups map[string]*ReportGroup, name string, u Update) {
+	g, ok := groups[name]
+	if !ok {
+		g = &ReportGroup{Name: name}
+		groups[name] = g
+	}
+	g.Count++
+	g.Entries = append(g.Entries, u)
+}
+
+// sortedGroups orders groups by entry count, then by name.
+func sortedGroups(groups map[string]*ReportGroup) []ReportGroup {
+	list := make([]ReportGroup, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, *g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// buildReport collects the entries matching filter into a digest. It reads
+// the same entry columns as /initial, just over an arbitrary range. Blockers
+// opened earlier that were still open when the range started are listed
+// before the ones posted in it.
+func (s *Server) buildReport(period string, filter EntryFilter) (*Report, error) {
+	now := time.Now()
+	rep := &Report{
+		Period:      period,
+		From:        filter.From.Format(dateLayout),
+		To:          filter.To.Format(dateLayout),
+		GeneratedAt: now.Format(time.RFC3339),
+		Severities:  map[string]int{},
+		TopTags:     []ReportCount{},
+		Blockers:    []Blocker{},
+	}
+
+	carried, err := s.db.Query(SelectCarriedBlockersQuery, rep.From, rep.From,
+		filter.User, filter.User, filter.Tag, filter.Tag)
+	if err != nil {
+		return nil, err
+	}
+	for carried.Next() {
+		u, err := scanUpdate(carried)
+		if err != nil {
+			continue
+		}
+		rep.Blockers = append(rep.Blockers, newBlocker(u, now))
+	}
+	carried.Close() // nolint:errcheck
+	if err := carried.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(SelectEntriesInRangeQuery, filter.args()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint:errcheck
+
+	users := map[string]*ReportGroup{}
+	tags := map[string]*ReportGroup{}
+	for rows.Next() {
+		u, err := scanUpdate(rows)
+		if err != nil {
+			continue
+		}
+
+		rep.Total++
+		rep.Severities[u.Severity]++
+		groupEntries(users, u.Username, u)
+		for _, tag := range u.Tags {
+			groupEntries(tags, tag, u)
+		}
+		if u.Status != "" {
+			rep.Blockers = append(rep.Blockers, newBlocker(u, now))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rep.Users = sortedGroups(users)
+	rep.Tags = sortedGroups(tags)
+	for i, g := range rep.Tags {
+		if i == reportTopTags {
+			break
+		}
+		rep.TopTags = append(rep.TopTags, ReportCount{Name: g.Name, Count: g.Count})
+	}
+	return rep, nil
+}
+
+// Title names the report, e.g. "HAL 9000 // weekly digest 2025-01-06 to 2025-01-12".
+func (rep *Report) Title() string {
+	if rep.From == rep.To {
+		return fmt.Sprintf("HAL 9000 // %s digest %s", rep.Period, rep.From)
+	}
+	return fmt.Sprintf("HAL 9000 // %s digest %s to %s", rep.Period, rep.From, rep.To)
+}
+
+// entryLine renders an entry as a Markdown list item. Weekly reports
+// include the date, daily ones only the time.
+func (rep *Report) entryLine(u Update, withUser bool) string {
+	var b strings.Builder
+
+	layout := "15:04"
+	if rep.From != rep.To {
+		layout = "Mon 01-02 15:04"
+	}
+	b.WriteString("- " + parseTimestamp(u.Timestamp).In(time.Local).Format(layout) + " ")
+	if withUser {
+		b.WriteString("[" + u.Username + "] ")
+	}
+	if u.Severity != "" && u.Severity != SeverityInfo {
+		b.WriteString("**" + u.Severity + "** ")
+	}
+	b.WriteString(strings.ReplaceAll(u.Message, "\n", "\n  "))
+	if u.Resolution != nil {
+		fmt.Fprintf(&b, " _(resolved by %s: %s)_", u.Resolution.ResolvedBy, u.Resolution.Note)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// Markdown renders the report for pasting into documents or plain-text mail.
+func (rep *Report) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n\n", rep.Title())
+	fmt.Fprintf(&b, "%d entries from %d crew members", rep.Total, len(rep.Users))
+	for _, sev := range []string{SeverityCrit, SeverityWarn} {
+		if n := rep.Severities[sev]; n > 0 {
+			fmt.Fprintf(&b, ", %d %s", n, sev)
+		}
+	}
+	b.WriteString(".\n")
+	if len(rep.TopTags) > 0 {
+		b.WriteString("\nTop tags:")
+		for _, t := range rep.TopTags {
+			fmt.Fprintf(&b, " #%s (%d)", t.Name, t.Count)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(rep.Blockers) > 0 {
+		b.WriteString("\n## Blockers\n\n")
+		for _, bl := range rep.Blockers {
+			fmt.Fprintf(&b, "- **%s** [%s] %s (%s)\n", strings.ToUpper(bl.Status), bl.Username, bl.Message, bl.Age)
+		}
+	}
+
+	if len(rep.Users) > 0 {
+		b.WriteString("\n## By crew member\n")
+		for _, g := range rep.Users {
+			fmt.Fprintf(&b, "\n### %s (%d)\n\n", g.Name, g.Count)
+			for _, u := range g.Entries {
+				b.WriteString(rep.entryLine(u, false))
+			}
+		}
+	}
+
+	if len(rep.Tags) > 0 {
+		b.WriteString("\n## By tag\n")
+		for _, g := range rep.Tags {
+			fmt.Fprintf(&b, "\n### #%s (%d)\n\n", g.Name, g.Count)
+			for _, u := range g.Entries {
+				b.WriteString(rep.entryLine(u, true))
+			}
+		}
+	}
+	return b.String()
+}
+
+// handleReport serves the daily or weekly digest containing ?date= (default
+// today) as JSON, Markdown or HTML, chosen with ?format=. The user and tag
+// parameters narrow the report as in /export; its from and to are rejected,
+// since the period sets the range.
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	period, err := parseReportPeriod(r.PathValue("period"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Has("from") || r.URL.Query().Has("to") {
+		http.Error(w, "reports cover the day or week of ?date=, not from and to", http.StatusBadRequest)
+		return
+	}
+
+	day := time.Now()
+	if v := r.URL.Query().Get("date"); v != "" {
+		day, err = time.ParseInLocation(dateLayout, v, time.Local)
+		if err != nil {
+			http.Error(w, "invalid date", http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter, err := s.parseEntryFilter(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.From, filter.To = reportRange(period, day)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "md" && format != "html" {
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	rep, err := s.buildReport(period, filter)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(rep.Markdown())) // nolint:errcheck
+	case "html":
+		s.renderPage(w, "report.html", struct {
+			Title  string
+			Report *Report
+		}{rep.Title(), rep})
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rep) // nolint:errcheck
+	}
+}
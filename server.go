@@ -1,30 +1,54 @@
 package main
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 )
 
-// processTags takes a slice of tags and normalizes them.
-// Converts to uppercase, Replaces spaces with underscores, Trims whitespace
-func processTags(tags []string) []string {
-	if len(tags) == 0 {
-		return tags
+// dateLayout is the day format used in query parameters. Stored timestamps
+// are RFC3339, so their first ten characters compare against it directly.
+const dateLayout = "2006-01-02"
+
+// parseDateRange reads the from and to query parameters as YYYY-MM-DD dates.
+// A missing to defaults to today and a missing from to days before it;
+// if days is zero the range is unbounded at the start.
+func parseDateRange(r *http.Request, days int) (time.Time, time.Time, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	to := today
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.ParseInLocation(dateLayout, v, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date %q", v)
+		}
+		to = t
 	}
 
-	processed := make([]string, len(tags))
-	for i, tag := range tags {
-		tag = strings.TrimSpace(tag)
-		tag = strings.ToUpper(tag)
-		tag = strings.ReplaceAll(tag, " ", "_")
-		processed[i] = tag
+	var from time.Time
+	if days > 0 {
+		from = to.AddDate(0, 0, -(days - 1))
+	}
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.ParseInLocation(dateLayout, v, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from date %q", v)
+		}
+		from = t
 	}
-	return processed
+
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from date is after to date")
+	}
+	return from, to, nil
 }
 
 type User struct {
@@ -33,28 +57,143 @@ type User struct {
 	Token    string `json:"token,omitempty"`
 }
 
+// User kinds stored in users.kind. System users are the accounts that
+// integrations and receivers post as.
+const (
+	UserKindCrew   = "crew"
+	UserKindSystem = "system"
+)
+
+// Entry kinds stored in log_entries.kind.
+const (
+	EntryKindLog     = "log"
+	EntryKindStandup = "standup"
+)
+
 type Update struct {
 	ID        int64    `json:"id"`
 	Username  string   `json:"username,omitempty"`
 	Message   string   `json:"message"`
 	Tags      []string `json:"tags,omitempty"`
 	Timestamp string   `json:"timestamp"`
+	Kind      string   `json:"kind"`
+	Severity  string   `json:"severity"`
+	Standup   *Standup `json:"standup,omitempty"`
+
+	Ack             *Acknowledgement `json:"ack,omitempty"`
+	EscalationLevel int              `json:"escalation_level,omitempty"`
+	Escalations     []Escalation     `json:"escalations,omitempty"`
+
+	// Status is "open" or "resolved" for blockers and empty otherwise.
+	Status     string      `json:"status,omitempty"`
+	Resolution *Resolution `json:"resolution,omitempty"`
+
+	// Event names the stream event carrying this entry. It is empty for
+	// newly posted entries and set when an existing entry changes.
+	Event string `json:"event,omitempty"`
+}
+
+// Config holds the server options set from the command line.
+type Config struct {
+	// StripHashtags removes #hashtags from the message body once they
+	// have been extracted into tags.
+	StripHashtags bool
+
+	// TagPipeline is the list of normalization steps applied to every tag.
+	TagPipeline []tagNormalizer
+
+	// TagAllowList rejects tags that are not part of the managed taxonomy.
+	TagAllowList bool
+
+	// AdminToken guards the admin endpoints. They are disabled when empty.
+	AdminToken string
+
+	// AlertSinks receive CRIT entries in addition to the normal broadcast.
+	AlertSinks []AlertSink
+
+	// EscalationWindow is how long a CRIT entry may go unacknowledged before
+	// it is escalated, and again between escalations. Zero disables escalation.
+	EscalationWindow time.Duration
+
+	// EscalationMax is the number of times an entry is escalated at most.
+	EscalationMax int
+
+	// EscalationSinks are notified every time an entry is escalated.
+	EscalationSinks []AlertSink
+
+	// WebhookMaxAttempts is the number of delivery attempts before a webhook
+	// delivery is dead-lettered.
+	WebhookMaxAttempts int
+
+	// WebhookBackoff is the delay before the first retry of a failed
+	// delivery. It doubles with every further attempt.
+	WebhookBackoff time.Duration
+
+	// IdempotencyTTL is how long Idempotency-Key responses are kept for replay.
+	IdempotencyTTL time.Duration
+
+	// PublicURL is the externally visible base URL used for absolute links.
+	// When empty, links are built from the incoming request.
+	PublicURL string
+
+	// TemplateDir holds the templates of the server-rendered pages.
+	TemplateDir string
+
+	// Mailer sends outgoing mail. It is nil when no SMTP server is configured.
+	Mailer *Mailer
+
+	// Digests enables digest subscriptions and the scheduler that mails them.
+	Digests bool
+
+	// DigestHour is the local hour at which digests for the previous day
+	// or week are sent.
+	DigestHour int
 }
 
 type Server struct {
+	cfg       Config
 	db        *sql.DB
 	clientsMu sync.RWMutex
 	clients   map[chan Update]struct{}
 	broadcast chan Update
+
+	webhookClient *http.Client
+	webhookWake   chan struct{}
+	webhookSlots  chan struct{}
+	webhookMu     sync.Mutex
+	webhookBusy   map[int64]bool
+
+	// webhookQueue holds the entries waiting for runWebhookQueue, and
+	// webhookQueued signals that it is not empty.
+	webhookQueueMu sync.Mutex
+	webhookQueue   []Update
+	webhookQueued  chan struct{}
+
+	templates *template.Template
 }
 
-func NewServer(db *sql.DB) *Server {
+func NewServer(db *sql.DB, cfg Config) *Server {
 	s := &Server{
-		db:        db,
-		clients:   make(map[chan Update]struct{}),
-		broadcast: make(chan Update, 32),
+		cfg:           cfg,
+		db:            db,
+		clients:       make(map[chan Update]struct{}),
+		broadcast:     make(chan Update, 32),
+		webhookClient: &http.Client{Timeout: 10 * time.Second},
+		webhookQueued: make(chan struct{}, 1),
+		webhookWake:   make(chan struct{}, 1),
+		webhookSlots:  make(chan struct{}, webhookWorkers),
+		webhookBusy:   make(map[int64]bool),
+		templates:     Must(loadTemplates(cfg.TemplateDir)),
 	}
 	go s.runBroadcaster()
+	go s.runWebhookQueue()
+	go s.runWebhookDeliveries()
+	if cfg.EscalationWindow > 0 {
+		go s.runEscalations()
+	}
+	if cfg.Digests {
+		go s.runDigests()
+	}
 	return s
 }
 
@@ -68,6 +207,10 @@ func (s *Server) runBroadcaster() {
 			}
 		}
 		s.clientsMu.RUnlock()
+
+		// Webhook deliveries are written to the database by their own
+		// goroutine, so a slow write never holds up live clients.
+		s.queueWebhooks(u)
 	}
 }
 
@@ -86,6 +229,22 @@ func (s *Server) removeClient(ch chan Update) {
 	s.clientsMu.Unlock()
 }
 
+// baseURL returns the absolute URL of the server without a trailing slash.
+func (s *Server) baseURL(r *http.Request) string {
+	if s.cfg.PublicURL != "" {
+		return strings.TrimRight(s.cfg.PublicURL, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
 func (s *Server) getUserByToken(token string) (*User, error) {
 	var user User
 	err := s.db.QueryRow(GetUserByTokenQuery, token).Scan(&user.ID, &user.Username)
@@ -95,13 +254,29 @@ func (s *Server) getUserByToken(token string) (*User, error) {
 	return &user, nil
 }
 
-func (s *Server) createUser(username string) (*User, error) {
+// requireAdmin checks the X-Admin-Token header against the configured admin token.
+// It writes an error response and returns false if the request is not allowed.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.cfg.AdminToken == "" {
+		http.Error(w, "admin endpoints disabled", http.StatusForbidden)
+		return false
+	}
+
+	token := r.Header.Get("X-Admin-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) != 1 {
+		http.Error(w, "invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (s *Server) createUser(username, kind string) (*User, error) {
 	username = strings.ToUpper(strings.TrimSpace(username))
 
 	token := generateToken()
 	timestamp := time.Now().Format(time.RFC3339)
 
-	res, err := s.db.Exec(CreateUserQuery, username, token, timestamp)
+	res, err := s.db.Exec(CreateUserQuery, username, token, timestamp, kind)
 	if err != nil {
 		return nil, err
 	}
@@ -115,15 +290,234 @@ func (s *Server) createUser(username string) (*User, error) {
 }
 
 func (s *Server) insertUpdate(u *Update, userID int64) error {
-	res, err := s.db.Exec(InsertEntryQuery, userID, u.Message, strings.Join(u.Tags, ","), u.Timestamp)
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback() // nolint:errcheck
 
+	if err := insertUpdateTx(tx, u, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertUpdateTx inserts an entry as part of a larger transaction.
+func insertUpdateTx(tx *sql.Tx, u *Update, userID int64) error {
+	if u.Kind == "" {
+		u.Kind = EntryKindLog
+	}
+	if u.Severity == "" {
+		u.Severity = SeverityInfo
+	}
+
+	res, err := tx.Exec(InsertEntryQuery, userID, u.Message, strings.Join(u.Tags, ","), u.Timestamp, u.Kind, u.Status, u.Severity)
+	if err != nil {
+		return err
+	}
 	u.ID, _ = res.LastInsertId()
+
+	if u.Standup != nil {
+		if _, err := tx.Exec(InsertStandupQuery, u.ID, u.Standup.Yesterday, u.Standup.Today, u.Standup.Blockers); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUpdate reads an entry selected with the entrySelect columns.
+func scanUpdate(rows rowScanner) (Update, error) {
+	var (
+		u          Update
+		username   sql.NullString
+		tags       string
+		yesterday  sql.NullString
+		today      sql.NullString
+		blockers   sql.NullString
+		resolution string
+		resolvedAt string
+		resolvedBy sql.NullString
+		ackedAt    string
+		ackedBy    sql.NullString
+	)
+	err := rows.Scan(&u.ID, &username, &u.Message, &tags, &u.Timestamp, &u.Kind,
+		&yesterday, &today, &blockers,
+		&u.Status, &resolution, &resolvedAt, &resolvedBy,
+		&u.Severity, &ackedAt, &ackedBy, &u.EscalationLevel)
+	if err != nil {
+		return u, err
+	}
+
+	u.Tags = splitTags(tags)
+	if username.Valid {
+		u.Username = username.String
+	}
+	if u.Kind == EntryKindStandup {
+		u.Standup = &Standup{
+			Yesterday: yesterday.String,
+			Today:     today.String,
+			Blockers:  blockers.String,
+		}
+	}
+	if ackedAt != "" {
+		u.Ack = &Acknowledgement{
+			AckedBy: ackedBy.String,
+			AckedAt: ackedAt,
+		}
+	}
+	if u.Status == BlockerStatusResolved {
+		u.Resolution = &Resolution{
+			Note:       resolution,
+			ResolvedBy: resolvedBy.String,
+			ResolvedAt: resolvedAt,
+		}
+	}
+	return u, nil
+}
+
+// getUpdate loads a single entry by ID.
+func (s *Server) getUpdate(id int64) (Update, error) {
+	return scanUpdate(s.db.QueryRow(SelectEntryByIDQuery, id))
+}
+
+// newUpdate builds an entry from a user's message and tags. Hashtags in the
+// message are merged into the tags, which are then resolved against the taxonomy.
+func (s *Server) newUpdate(user *User, message string, tags []string) (Update, error) {
+	hashtags, stripped := extractHashtags(message)
+	if s.cfg.StripHashtags {
+		message = stripped
+	}
+
+	tags, err := s.resolveTags(append(tags, hashtags...))
+	if err != nil {
+		return Update{}, err
+	}
+	return newEntry(user, message, tags), nil
+}
+
+// newEntry builds a log entry from tags that are already resolved.
+func newEntry(user *User, message string, tags []string) Update {
+	u := Update{
+		Username:  user.Username,
+		Message:   message,
+		Tags:      tags,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Kind:      EntryKindLog,
+		Severity:  SeverityInfo,
+	}
+	if isBlocker(tags) {
+		u.Status = BlockerStatusOpen
+	}
+	return u
+}
+
+// publish hands an entry to the broadcaster without blocking the caller.
+// New CRIT entries are also routed to the alert sinks.
+func (s *Server) publish(u Update) {
+	select {
+	case s.broadcast <- u:
+	default:
+		go func() { s.broadcast <- u }()
+	}
+	s.alertCritical(u)
+}
+
+// publishAll hands several entries to the broadcaster in order. They are
+// sent from a single goroutine, so the caller doesn't wait and they reach
+// clients in the order given.
+func (s *Server) publishAll(list []Update) {
+	go func() {
+		for _, u := range list {
+			s.broadcast <- u
+		}
+	}()
+	for _, u := range list {
+		s.alertCritical(u)
+	}
+}
+
+// alertCritical routes a new CRIT entry to the alert sinks.
+func (s *Server) alertCritical(u Update) {
+	if u.Event == "" && u.Severity == SeverityCrit && len(s.cfg.AlertSinks) > 0 {
+		go s.routeAlert(u)
+	}
+}
+
+// BadRequestError is returned for input that fails validation.
+type BadRequestError struct {
+	Msg string
+}
+
+func (e *BadRequestError) Error() string {
+	return e.Msg
+}
+
+// isBadRequest reports whether err was caused by the client's input
+// rather than by the server.
+func isBadRequest(err error) bool {
+	var unknown *UnknownTagError
+	var bad *BadRequestError
+	return errors.As(err, &unknown) || errors.As(err, &bad)
+}
+
+// writeUpdateError reports a failure to build an entry, distinguishing
+// rejected input from database errors.
+func writeUpdateError(w http.ResponseWriter, err error) {
+	if isBadRequest(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "database error", http.StatusInternalServerError)
+}
+
+// UpdateRequest is the body accepted by /update.
+type UpdateRequest struct {
+	Message  string   `json:"message"`
+	Tags     []string `json:"tags"`
+	Severity string   `json:"severity"`
+}
+
+// updateFromRequest validates a posted update and builds the entry for it.
+func (s *Server) updateFromRequest(user *User, in UpdateRequest) (Update, error) {
+	if in.Message == "" {
+		return Update{}, &BadRequestError{Msg: "empty message"}
+	}
+
+	severity, err := ParseSeverity(in.Severity)
+	if err != nil {
+		return Update{}, &BadRequestError{Msg: err.Error()}
+	}
+
+	u, err := s.newUpdate(user, in.Message, in.Tags)
+	if err != nil {
+		return Update{}, err
+	}
+	u.Severity = severity
+	return u, nil
+}
+
+// authenticate resolves the user from the X-Auth-Token header.
+// It writes an error response and returns false if the token is missing or invalid.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	token := r.Header.Get("X-Auth-Token")
+	if token == "" {
+		http.Error(w, "authentication token required", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	user, err := s.getUserByToken(token)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+	return user, true
+}
+
 func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	var in struct {
 		Username string `json:"username"`
@@ -138,7 +532,7 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := s.createUser(in.Username)
+	user, err := s.createUser(in.Username, UserKindCrew)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 			http.Error(w, "username already exists", http.StatusConflict)
@@ -153,6 +547,8 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user) // nolint:errcheck
 }
 
+// handleUserIndex serves a crew member's page: the web UI by default, or
+// today's entries as JSON, a text table or Markdown, depending on Accept.
 func (s *Server) handleUserIndex(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/user/")
 	username := strings.Trim(path, "/")
@@ -171,15 +567,55 @@ func (s *Server) handleUserIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.ServeFile(w, r, "./index.html")
+	minSeverity, err := parseMinSeverity(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list, err := s.todayEntries(actualUsername, minSeverity)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeEntries(w, r, feedPage{
+		Title:   "HAL Activity Monitor - " + actualUsername,
+		Heading: "[ HAL ] Monitoring Protocol: " + actualUsername,
+		User:    actualUsername,
+		Entries: list,
+		Boot:    true,
+		Live:    true,
+	}, mediaHTML, mediaJSON, mediaText, mediaMarkdown)
 }
 
+// handleIndex serves the web UI with today's entries of the whole crew, or
+// the entries as JSON, a text table or Markdown, depending on Accept.
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return
 	}
-	http.ServeFile(w, r, "./index.html")
+
+	minSeverity, err := parseMinSeverity(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list, err := s.todayEntries("", minSeverity)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeEntries(w, r, feedPage{
+		Title:   "Work Log",
+		Heading: "COMMS Monitoring Protocol: ACTIVE",
+		Entries: list,
+		Boot:    true,
+		Live:    true,
+	}, mediaHTML, mediaJSON, mediaText, mediaMarkdown)
 }
 
 func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
@@ -193,6 +629,12 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	filter, err := parseStreamFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	clientCh := make(chan Update, 16)
 	s.addClient(clientCh)
 	defer s.removeClient(clientCh)
@@ -204,103 +646,100 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 		case <-notify:
 			return
 		case u := <-clientCh:
+			if !filter.match(u) {
+				continue
+			}
 			b, _ := json.Marshal(u)
+			if u.Event != "" {
+				fmt.Fprintf(w, "event: %s\n", u.Event) // nolint:errcheck
+			}
 			fmt.Fprintf(w, "data: %s\n\n", b) // nolint:errcheck
 			flusher.Flush()
 		}
 	}
 }
 
-func (s *Server) handleInitial(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/initial")
-	username := strings.Trim(path, "/")
-
-	if username != "" {
-		username = strings.ToUpper(strings.TrimSpace(username))
-	}
-
+// todayEntries returns today's entries at or above a severity rank, of one
+// user or of everyone if username is empty.
+func (s *Server) todayEntries(username string, minSeverity int) ([]Update, error) {
 	var rows *sql.Rows
 	var err error
 
 	if username != "" {
-		rows, err = s.db.Query(SelectTodayEntriesByUserQuery, username)
+		rows, err = s.db.Query(SelectTodayEntriesByUserQuery, username, minSeverity)
 	} else {
-		rows, err = s.db.Query(SelectTodayEntriesQuery)
+		rows, err = s.db.Query(SelectTodayEntriesQuery, minSeverity)
 	}
 
 	if err != nil {
-		http.Error(w, "database error", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close() // nolint:errcheck
 
 	list := []Update{}
 
 	for rows.Next() {
-		var (
-			id       int64
-			username sql.NullString
-			msg      string
-			tags     string
-			ts       string
-		)
-		rows.Scan(&id, &username, &msg, &tags, &ts) // nolint:errcheck
-
-		update := Update{
-			ID:      id,
-			Message: msg,
-			Tags: func() []string {
-				if tags == "" {
-					return nil
-				}
-				return strings.Split(tags, ",")
-			}(),
-			Timestamp: ts,
+		update, err := scanUpdate(rows)
+		if err != nil {
+			continue
 		}
-
-		if username.Valid {
-			update.Username = username.String
-		}
-
 		list = append(list, update)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(list) // nolint:errcheck
+	return list, rows.Err()
 }
 
-func (s *Server) handlePost(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("X-Auth-Token")
-	if token == "" {
-		http.Error(w, "authentication token required", http.StatusUnauthorized)
+// handleInitial returns today's entries, as JSON by default or as HTML, a
+// text table or Markdown, depending on Accept.
+func (s *Server) handleInitial(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/initial")
+	username := strings.Trim(path, "/")
+
+	if username != "" {
+		username = strings.ToUpper(strings.TrimSpace(username))
+	}
+
+	minSeverity, err := parseMinSeverity(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	user, err := s.getUserByToken(token)
+	list, err := s.todayEntries(username, minSeverity)
 	if err != nil {
-		http.Error(w, "invalid token", http.StatusUnauthorized)
+		http.Error(w, "database error", http.StatusInternalServerError)
 		return
 	}
 
-	var in struct {
-		Message string   `json:"message"`
-		Tags    []string `json:"tags"`
+	page := feedPage{
+		Title:   "Work Log",
+		Heading: "COMMS Monitoring Protocol: ACTIVE",
+		Entries: list,
+		Live:    true,
 	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+	if username != "" {
+		page.Title = "HAL Activity Monitor - " + username
+		page.Heading = "[ HAL ] Monitoring Protocol: " + username
+		page.User = username
+	}
+	s.writeEntries(w, r, page, mediaJSON, mediaHTML, mediaText, mediaMarkdown)
+}
+
+func (s *Server) handlePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(w, r)
+	if !ok {
 		return
 	}
 
-	if in.Message == "" {
-		http.Error(w, "empty message", http.StatusBadRequest)
+	var in UpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	u := Update{
-		Username:  user.Username,
-		Message:   in.Message,
-		Tags:      processTags(in.Tags),
-		Timestamp: time.Now().Format(time.RFC3339),
+	u, err := s.updateFromRequest(user, in)
+	if err != nil {
+		writeUpdateError(w, err)
+		return
 	}
 
 	if err := s.insertUpdate(&u, user.ID); err != nil {
@@ -308,11 +747,7 @@ func (s *Server) handlePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	select {
-	case s.broadcast <- u:
-	default:
-		go func() { s.broadcast <- u }()
-	}
+	s.publish(u)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Severity levels, from least to most severe.
+const (
+	SeverityInfo = "INFO"
+	SeverityWarn = "WARN"
+	SeverityCrit = "CRIT"
+)
+
+// severityRanks orders the severity levels. The SQL filter in
+// severityRankExpr must agree with it.
+var severityRanks = map[string]int{
+	SeverityInfo: 0,
+	SeverityWarn: 1,
+	SeverityCrit: 2,
+}
+
+// severityAliases are the spellings accepted in addition to the levels themselves.
+var severityAliases = map[string]string{
+	"WARNING":  SeverityWarn,
+	"CRITICAL": SeverityCrit,
+}
+
+// ParseSeverity normalizes a severity level. An empty string means INFO.
+func ParseSeverity(v string) (string, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	if v == "" {
+		return SeverityInfo, nil
+	}
+	if alias, ok := severityAliases[v]; ok {
+		v = alias
+	}
+	if _, ok := severityRanks[v]; !ok {
+		return "", fmt.Errorf("invalid severity %q", v)
+	}
+	return v, nil
+}
+
+// severityRank returns the rank of a stored severity, treating unknown values as INFO.
+func severityRank(severity string) int {
+	return severityRanks[severity]
+}
+
+// parseMinSeverity reads the ?severity= minimum level from a request, returning its rank.
+func parseMinSeverity(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("severity")
+	if v == "" {
+		return 0, nil
+	}
+	severity, err := ParseSeverity(v)
+	if err != nil {
+		return 0, err
+	}
+	return severityRank(severity), nil
+}
+
+// streamFilter decides which broadcast entries a stream subscriber receives.
+type streamFilter struct {
+	minSeverity int
+}
+
+// parseStreamFilter reads the stream filters from the query string.
+func parseStreamFilter(r *http.Request) (streamFilter, error) {
+	minSeverity, err := parseMinSeverity(r)
+	if err != nil {
+		return streamFilter{}, err
+	}
+	return streamFilter{minSeverity: minSeverity}, nil
+}
+
+func (f streamFilter) match(u Update) bool {
+	return severityRank(u.Severity) >= f.minSeverity
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sshKeyTypes are the public key algorithms accepted for SSH login.
+var sshKeyTypes = []string{
+	"ssh-ed25519", "ssh-rsa",
+	"ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521",
+	"sk-ssh-ed25519@openssh.com", "sk-ecdsa-sha2-nistp256@openssh.com",
+}
+
+// SSHKey is a public key a user logs in to the SSH interface with.
+type SSHKey struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"public_key"`
+	Comment     string `json:"comment,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func scanSSHKey(rows rowScanner) (SSHKey, error) {
+	var k SSHKey
+	err := rows.Scan(&k.ID, &k.Username, &k.Type, &k.Fingerprint, &k.PublicKey, &k.Comment, &k.CreatedAt)
+	return k, err
+}
+
+// ParseAuthorizedKey parses a public key in authorized_keys format, such as
+// the contents of ~/.ssh/id_ed25519.pub: "ssh-ed25519 AAAA... comment".
+func ParseAuthorizedKey(line string) (SSHKey, error) {
+	var k SSHKey
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return k, errors.New("public key must look like \"ssh-ed25519 AAAA... comment\"")
+	}
+	if !slices.Contains(sshKeyTypes, fields[0]) {
+		return k, fmt.Errorf("unsupported key type %q", fields[0])
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return k, errors.New("public key is not valid base64")
+	}
+
+	// The key blob starts with its type as a length-prefixed string.
+	if len(blob) < 4 {
+		return k, errors.New("public key is truncated")
+	}
+	n := binary.BigEndian.Uint32(blob)
+	if uint64(len(blob)) < 4+uint64(n) || !bytes.Equal(blob[4:4+n], []byte(fields[0])) {
+		return k, errors.New("public key does not match its type")
+	}
+
+	sum := sha256.Sum256(blob)
+	k.Type = fields[0]
+	k.Fingerprint = "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+	k.PublicKey = fields[0] + " " + fields[1]
+	k.Comment = strings.Join(fields[2:], " ")
+	return k, nil
+}
+
+func (s *Server) handleSSHKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := s.db.Query(SelectSSHKeysByUserQuery, user.ID)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close() // nolint:errcheck
+
+	list := []SSHKey{}
+	for rows.Next() {
+		k, err := scanSSHKey(rows)
+		if err != nil {
+			continue
+		}
+		list = append(list, k)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list) // nolint:errcheck
+}
+
+// handleAddSSHKey registers a public key for the caller. A key can belong to
+// only one user.
+func (s *Server) handleAddSSHKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var in struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	k, err := ParseAuthorizedKey(in.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	k.Username = user.Username
+	k.CreatedAt = time.Now().Format(time.RFC3339)
+
+	res, err := s.db.Exec(InsertSSHKeyQuery, user.ID, k.Type, k.Fingerprint, k.PublicKey, k.Comment, k.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			http.Error(w, "key already registered", http.StatusConflict)
+			return
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	k.ID, _ = res.LastInsertId()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(k) // nolint:errcheck
+}
+
+func (s *Server) handleDeleteSSHKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.db.Exec(DeleteSSHKeyQuery, id, user.ID)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAuthorizeSSHKey looks up the user a public key belongs to. It is
+// used by the SSH interface to log users in, and returns their token so the
+// session can post on their behalf. That makes the admin token good for
+// posting as anyone with a registered key, so the SSH interface is meant to
+// run next to the server and reach it over a trusted network.
+func (s *Server) handleAuthorizeSSHKey(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var in struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	k, err := ParseAuthorizedKey(in.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	err = s.db.QueryRow(SelectUserBySSHKeyQuery, k.Fingerprint).Scan(&user.ID, &user.Username, &user.Token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "unknown key", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user) // nolint:errcheck
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Standup holds the structured sections of a standup entry.
+type Standup struct {
+	Yesterday string `json:"yesterday,omitempty"`
+	Today     string `json:"today,omitempty"`
+	Blockers  string `json:"blockers,omitempty"`
+}
+
+// Message renders the sections as the plain-text message stored with the
+// entry, so clients that don't know about standups still show something useful.
+func (st Standup) Message() string {
+	var lines []string
+	if st.Yesterday != "" {
+		lines = append(lines, "YESTERDAY: "+st.Yesterday)
+	}
+	if st.Today != "" {
+		lines = append(lines, "TODAY: "+st.Today)
+	}
+	if st.Blockers != "" {
+		lines = append(lines, "BLOCKERS: "+st.Blockers)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// StandupSummary lists who has and hasn't submitted a standup on a day.
+type StandupSummary struct {
+	Date      string   `json:"date"`
+	Submitted []Update `json:"submitted"`
+	Missing   []string `json:"missing"`
+}
+
+func (s *Server) handlePostStandup(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var in struct {
+		Standup
+		Tags     []string `json:"tags"`
+		Severity string   `json:"severity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	st := Standup{
+		Yesterday: strings.TrimSpace(in.Yesterday),
+		Today:     strings.TrimSpace(in.Today),
+		Blockers:  strings.TrimSpace(in.Blockers),
+	}
+	message := st.Message()
+	if message == "" {
+		http.Error(w, "empty standup", http.StatusBadRequest)
+		return
+	}
+
+	severity, err := ParseSeverity(in.Severity)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u, err := s.newUpdate(user, message, in.Tags)
+	if err != nil {
+		writeUpdateError(w, err)
+		return
+	}
+	u.Kind = EntryKindStandup
+	u.Severity = severity
+	u.Standup = &st
+	if st.Blockers != "" {
+		u.Status = BlockerStatusOpen
+	}
+
+	if err := s.insertUpdate(&u, user.ID); err != nil {
+		http.Error(w, "failed to insert standup", http.StatusInternalServerError)
+		return
+	}
+
+	s.publish(u)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(u) // nolint:errcheck
+}
+
+// handleStandupSummary reports the standups posted on ?date= (default today)
+// and the crew members who haven't posted one yet. Crew who joined after
+// that day and system accounts, such as syslog and integrations, are not
+// expected to.
+func (s *Server) handleStandupSummary(w http.ResponseWriter, r *http.Request) {
+	date := time.Now().Format(dateLayout)
+	if v := r.URL.Query().Get("date"); v != "" {
+		if _, err := time.Parse(dateLayout, v); err != nil {
+			http.Error(w, "invalid date", http.StatusBadRequest)
+			return
+		}
+		date = v
+	}
+
+	rows, err := s.db.Query(SelectStandupsForDayQuery, date)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close() // nolint:errcheck
+
+	summary := StandupSummary{
+		Date:      date,
+		Submitted: []Update{},
+		Missing:   []string{},
+	}
+
+	// Only the latest standup per user counts.
+	latest := map[string]int{}
+	for rows.Next() {
+		u, err := scanUpdate(rows)
+		if err != nil {
+			continue
+		}
+		if i, ok := latest[u.Username]; ok {
+			summary.Submitted[i] = u
+			continue
+		}
+		latest[u.Username] = len(summary.Submitted)
+		summary.Submitted = append(summary.Submitted, u)
+	}
+
+	users, err := s.db.Query(SelectCrewUsernamesQuery, date, date)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer users.Close() // nolint:errcheck
+
+	for users.Next() {
+		var username string
+		users.Scan(&username) // nolint:errcheck
+		if _, ok := latest[username]; !ok {
+			summary.Missing = append(summary.Missing, username)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary) // nolint:errcheck
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStandupSummaryExpectsCrewOnly(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	if _, err := s.createUser("dave", UserKindCrew); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.getOrCreateSystemUser("SYSLOG"); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := func(date string) []string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.handleStandupSummary(w, httptest.NewRequest(http.MethodGet, "/standup?date="+date, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		var summary StandupSummary
+		if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+			t.Fatal(err)
+		}
+		return summary.Missing
+	}
+
+	if got := missing(time.Now().Format(dateLayout)); !slices.Equal(got, []string{"DAVE"}) {
+		t.Errorf("missing today %q, want only DAVE", got)
+	}
+	if got := missing(time.Now().AddDate(0, 0, -7).Format(dateLayout)); len(got) != 0 {
+		t.Errorf("missing a week ago %q, want nobody", got)
+	}
+}
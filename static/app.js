@@ -1,5 +1,9 @@
-// Get current user from URL path
+// Get current user from the server-rendered page, or else from the URL path
 function getCurrentUser() {
+    const log = document.getElementById("log");
+    if (log && log.dataset.user) {
+        return log.dataset.user;
+    }
     const path = window.location.pathname;
     if (path === '/') return null;
     if (path.startsWith('/user/')) {
@@ -19,13 +23,36 @@ async function typewriter(el, text, speed = 25) {
 function createEntrySkeleton(u) {
     const wrap = document.createElement("div");
     wrap.className = "entry";
+    wrap.dataset.id = u.id;
+    if (u.kind && u.kind !== "log") {
+        wrap.classList.add(u.kind);
+    }
+    if (u.status) {
+        wrap.classList.add(`blocker-${u.status}`);
+    }
+    if (u.severity && u.severity !== "INFO") {
+        wrap.classList.add(`severity-${u.severity.toLowerCase()}`);
+    }
+    if (u.escalation_level) {
+        wrap.classList.add("escalated");
+        wrap.dataset.escalation = `ESCALATION ${u.escalation_level}`;
+    }
+    if (u.ack) {
+        wrap.classList.add("acked");
+    }
 
     const ts = document.createElement("div");
     ts.className = "ts";
+    const permalink = document.createElement("a");
+    permalink.href = `/entry/${u.id}`;
+    ts.appendChild(permalink);
     wrap.appendChild(ts);
 
     const user = document.createElement("div");
     user.className = "username";
+    const userLink = document.createElement("a");
+    userLink.href = `/user/${encodeURIComponent(u.username || "")}`;
+    user.appendChild(userLink);
     wrap.appendChild(user);
 
     const msg = document.createElement("div");
@@ -38,9 +65,32 @@ function createEntrySkeleton(u) {
         wrap.appendChild(t);
     }
 
+    if (u.resolution) {
+        wrap.appendChild(createResolution(u.resolution));
+    }
+
     return wrap;
 }
 
+function createResolution(r) {
+    const el = document.createElement("div");
+    el.className = "resolution";
+    el.textContent = `resolved by [${r.resolved_by}]` + (r.note ? `: ${r.note}` : "");
+    return el;
+}
+
+function markResolved(u) {
+    const entry = document.querySelector(`#log .entry[data-id="${u.id}"]`);
+    if (!entry) {
+        return;
+    }
+    entry.classList.remove("blocker-open");
+    entry.classList.add("blocker-resolved");
+    if (u.resolution && !entry.querySelector(".resolution")) {
+        entry.appendChild(createResolution(u.resolution));
+    }
+}
+
 async function animateNewEntry(u) {
     const currentUser = getCurrentUser();
     
@@ -51,7 +101,7 @@ async function animateNewEntry(u) {
     const container = document.getElementById("log");
     const entry = createEntrySkeleton(u);
 
-    const tsEl = entry.querySelector(".ts");
+    const tsEl = entry.querySelector(".ts a");
     const userEl = entry.querySelector(".username");
     const msgEl = entry.querySelector(".msg");
     const tagsEl = entry.querySelector(".tags");
@@ -63,7 +113,7 @@ async function animateNewEntry(u) {
 
     if (userEl && u.username && !currentUser) {
         const usernameText = `[${u.username}]`;
-        await typewriter(userEl, usernameText, 25);
+        await typewriter(userEl.firstChild, usernameText, 25);
     } else if (userEl) {
         userEl.remove();
     }
@@ -86,6 +136,11 @@ async function animateNewEntry(u) {
 }
 
 async function loadInitial() {
+    // Server-rendered pages already show today's entries.
+    if (document.getElementById("log").dataset.rendered !== undefined) {
+        return;
+    }
+
     const currentUser = getCurrentUser();
     const endpoint = currentUser ? `/initial/${currentUser}` : '/initial';
     
@@ -102,12 +157,12 @@ async function loadInitial() {
     for (let i = list.length - 1; i >= 0; i--) {
         const e = createEntrySkeleton(list[i]);
         
-        const tsEl = e.querySelector(".ts");
+        const tsEl = e.querySelector(".ts a");
         tsEl.textContent = new Date(list[i].timestamp).toLocaleString();
         
         const userEl = e.querySelector(".username");
         if (userEl && list[i].username && !currentUser) {
-            userEl.textContent = `[${list[i].username}]`;
+            userEl.firstChild.textContent = `[${list[i].username}]`;
         } else if (userEl) {
             userEl.remove();
         }
@@ -131,6 +186,36 @@ function startStream() {
             console.error(err);
         }
     };
+    es.addEventListener("escalated", async e => {
+        try {
+            const u = JSON.parse(e.data);
+            const old = document.querySelector(`#log .entry[data-id="${u.id}"]`);
+            if (old) {
+                old.remove();
+            }
+            await animateNewEntry(u);
+        } catch (err) {
+            console.error(err);
+        }
+    });
+    es.addEventListener("acknowledged", e => {
+        try {
+            const u = JSON.parse(e.data);
+            const entry = document.querySelector(`#log .entry[data-id="${u.id}"]`);
+            if (entry) {
+                entry.classList.add("acked");
+            }
+        } catch (err) {
+            console.error(err);
+        }
+    });
+    es.addEventListener("resolved", e => {
+        try {
+            markResolved(JSON.parse(e.data));
+        } catch (err) {
+            console.error(err);
+        }
+    });
 }
 
 // Update page title and header based on current user
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSyslogMessage is the largest syslog message accepted over TCP.
+	maxSyslogMessage = 64 * 1024
+
+	// maxSyslogLengthDigits bounds the octet count that prefixes a message
+	// framed by octet counting.
+	maxSyslogLengthDigits = 10
+
+	// syslogRateWindow is the window over which the per-host rate limit applies.
+	syslogRateWindow = time.Minute
+)
+
+// syslogFacilities names the syslog facilities by code.
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "audit", "alert", "clock",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// syslogSeverities names the syslog severities by code.
+var syslogSeverities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// ParseSyslogSeverity accepts a syslog severity name or code.
+func ParseSyslogSeverity(v string) (int, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if i := slices.Index(syslogSeverities, v); i >= 0 {
+		return i, nil
+	}
+	switch v {
+	case "error":
+		return 3, nil
+	case "warn":
+		return 4, nil
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 && n < len(syslogSeverities) {
+		return n, nil
+	}
+	return 0, fmt.Errorf("invalid syslog severity %q", v)
+}
+
+// entrySeverity maps a syslog severity to an entry severity: emerg, alert
+// and crit are CRIT, err and warning are WARN, the rest INFO.
+func entrySeverity(severity int) string {
+	switch {
+	case severity <= 2:
+		return SeverityCrit
+	case severity <= 4:
+		return SeverityWarn
+	default:
+		return SeverityInfo
+	}
+}
+
+// SyslogMessage is a parsed RFC 5424 or RFC 3164 message.
+type SyslogMessage struct {
+	Facility int
+	Severity int
+	Hostname string
+	AppName  string
+	ProcID   string
+	MsgID    string
+	Message  string
+}
+
+// FacilityName returns the name of the message's facility.
+func (m SyslogMessage) FacilityName() string {
+	if m.Facility < len(syslogFacilities) {
+		return syslogFacilities[m.Facility]
+	}
+	return strconv.Itoa(m.Facility)
+}
+
+// ParseSyslog parses a single syslog message in RFC 5424 or RFC 3164 format.
+func ParseSyslog(b []byte) (SyslogMessage, error) {
+	var m SyslogMessage
+
+	line := strings.TrimRight(string(b), "\r\n\x00")
+	if !strings.HasPrefix(line, "<") {
+		return m, errors.New("missing priority")
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return m, errors.New("invalid priority")
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri > 191 {
+		return m, errors.New("invalid priority")
+	}
+	m.Facility, m.Severity = pri/8, pri%8
+	rest := line[end+1:]
+
+	if strings.HasPrefix(rest, "1 ") {
+		return parseSyslog5424(m, rest[2:])
+	}
+	return parseSyslog3164(m, rest), nil
+}
+
+// nilValue maps the RFC 5424 NILVALUE to an empty string.
+func nilValue(v string) string {
+	if v == "-" {
+		return ""
+	}
+	return v
+}
+
+// parseSyslog5424 parses what follows "<PRI>1 ":
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG].
+func parseSyslog5424(m SyslogMessage, rest string) (SyslogMessage, error) {
+	fields := strings.SplitN(rest, " ", 6)
+	if len(fields) < 6 {
+		return m, errors.New("truncated RFC 5424 header")
+	}
+	m.Hostname = nilValue(fields[1])
+	m.AppName = nilValue(fields[2])
+	m.ProcID = nilValue(fields[3])
+	m.MsgID = nilValue(fields[4])
+
+	// Skip the structured data, which is "-" or one or more [elements]
+	// whose quoted values may contain escaped brackets.
+	sd := fields[5]
+	i := 0
+	if strings.HasPrefix(sd, "-") {
+		i = 1
+	} else {
+		for i < len(sd) && sd[i] == '[' {
+			inQuote := false
+			for i++; i < len(sd); i++ {
+				c := sd[i]
+				if c == '\\' && inQuote {
+					i++
+				} else if c == '"' {
+					inQuote = !inQuote
+				} else if c == ']' && !inQuote {
+					i++
+					break
+				}
+			}
+		}
+	}
+	m.Message = strings.TrimPrefix(strings.TrimPrefix(sd[min(i, len(sd)):], " "), "\ufeff")
+	return m, nil
+}
+
+// syslog3164Header matches "Mmm dd hh:mm:ss HOST TAG[PID]: " of RFC 3164.
+var syslog3164Header = regexp.MustCompile(`^([A-Z][a-z]{2} [ 0-9]\d \d{2}:\d{2}:\d{2}) (\S+) (?:([^\s:\[]{1,48})(?:\[([^\]]*)\])?: ?)?`)
+
+// parseSyslog3164 parses what follows "<PRI>". Messages without a
+// recognizable header are kept whole.
+func parseSyslog3164(m SyslogMessage, rest string) SyslogMessage {
+	h := syslog3164Header.FindStringSubmatch(rest)
+	if h == nil {
+		m.Message = strings.TrimSpace(rest)
+		return m
+	}
+	m.Hostname, m.AppName, m.ProcID = h[2], h[3], h[4]
+	m.Message = strings.TrimSpace(rest[len(h[0]):])
+	return m
+}
+
+// SyslogConfig controls which messages the syslog receiver posts.
+type SyslogConfig struct {
+	// Username is the system user entries are attributed to. It is
+	// created if it doesn't exist.
+	Username string
+
+	// MinSeverity drops messages less severe than this syslog severity.
+	MinSeverity int
+
+	// RateLimit is the number of messages posted per host and minute.
+	// Zero disables the limit.
+	RateLimit int
+
+	// Exclude drops messages whose text matches.
+	Exclude *regexp.Regexp
+
+	// IgnoreHosts drops messages from these hosts (hostnames or addresses).
+	IgnoreHosts []string
+}
+
+// rateWindow counts the messages of one host in the current window.
+type rateWindow struct {
+	start   time.Time
+	count   int
+	dropped int
+}
+
+// logDropped reports the messages a host had dropped in the window.
+func (w *rateWindow) logDropped(host string) {
+	if w.dropped > 0 {
+		log.Printf("syslog: rate limited %s, dropped %d messages", host, w.dropped)
+	}
+}
+
+// SyslogReceiver turns syslog messages received over UDP and TCP into entries.
+type SyslogReceiver struct {
+	cfg  SyslogConfig
+	s    *Server
+	user *User
+
+	// hosts holds the rate windows of the hosts heard from recently. It
+	// is swept once per window so hosts that went quiet are forgotten.
+	mu    sync.Mutex
+	hosts map[string]*rateWindow
+	swept time.Time
+}
+
+// NewSyslogReceiver creates a receiver posting as cfg.Username.
+func NewSyslogReceiver(s *Server, cfg SyslogConfig) (*SyslogReceiver, error) {
+	user, err := s.getOrCreateSystemUser(cfg.Username)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogReceiver{
+		cfg:   cfg,
+		s:     s,
+		user:  user,
+		hosts: map[string]*rateWindow{},
+	}, nil
+}
+
+// ServeUDP reads one message per datagram until the connection is closed.
+func (sr *SyslogReceiver) ServeUDP(conn net.PacketConn) error {
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("syslog: %v", err)
+			continue
+		}
+		sr.receive(addr, buf[:n])
+	}
+}
+
+// ServeTCP accepts connections until the listener is closed.
+func (sr *SyslogReceiver) ServeTCP(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("syslog: %v", err)
+			continue
+		}
+		go sr.serveConn(conn)
+	}
+}
+
+// serveConn reads messages framed by octet counting or by newlines (RFC 6587).
+func (sr *SyslogReceiver) serveConn(conn net.Conn) {
+	defer conn.Close() // nolint:errcheck
+
+	br := bufio.NewReaderSize(conn, maxSyslogMessage)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return
+		}
+
+		var msg []byte
+		if b[0] >= '0' && b[0] <= '9' {
+			n, err := readOctetCount(br)
+			if err != nil || n <= 0 || n > maxSyslogMessage {
+				return
+			}
+			msg = make([]byte, n)
+			if _, err := io.ReadFull(br, msg); err != nil {
+				return
+			}
+		} else {
+			msg, err = br.ReadSlice('\n')
+			if err != nil && !(err == io.EOF && len(msg) > 0) {
+				return
+			}
+		}
+
+		if msg = bytes.TrimSpace(msg); len(msg) > 0 {
+			sr.receive(conn.RemoteAddr(), msg)
+		}
+	}
+}
+
+// readOctetCount reads the "LENGTH " prefix of an octet-counted message.
+// It gives up after maxSyslogLengthDigits, so a peer can't make it buffer
+// an endless prefix.
+func readOctetCount(br *bufio.Reader) (int, error) {
+	digits := make([]byte, 0, maxSyslogLengthDigits)
+	for len(digits) < maxSyslogLengthDigits {
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c == ' ' {
+			return strconv.Atoi(string(digits))
+		}
+		digits = append(digits, c)
+	}
+	return 0, errors.New("octet count too long")
+}
+
+// allow applies the per-host rate limit, logging how many messages were
+// dropped once a window is over.
+func (sr *SyslogReceiver) allow(host string, now time.Time) bool {
+	if sr.cfg.RateLimit <= 0 {
+		return true
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	if now.Sub(sr.swept) >= syslogRateWindow {
+		for h, w := range sr.hosts {
+			if now.Sub(w.start) >= syslogRateWindow {
+				w.logDropped(h)
+				delete(sr.hosts, h)
+			}
+		}
+		sr.swept = now
+	}
+
+	w, ok := sr.hosts[host]
+	if !ok || now.Sub(w.start) >= syslogRateWindow {
+		if ok {
+			w.logDropped(host)
+		}
+		w = &rateWindow{start: now}
+		sr.hosts[host] = w
+	}
+	if w.count >= sr.cfg.RateLimit {
+		w.dropped++
+		return false
+	}
+	w.count++
+	return true
+}
+
+// receive filters a raw message and posts it.
+func (sr *SyslogReceiver) receive(addr net.Addr, b []byte) {
+	m, err := ParseSyslog(b)
+	if err != nil {
+		return
+	}
+
+	remote := addr.String()
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if m.Hostname == "" {
+		m.Hostname = remote
+	}
+
+	if m.Severity > sr.cfg.MinSeverity || m.Message == "" {
+		return
+	}
+	if slices.Contains(sr.cfg.IgnoreHosts, m.Hostname) || slices.Contains(sr.cfg.IgnoreHosts, remote) {
+		return
+	}
+	if sr.cfg.Exclude != nil && sr.cfg.Exclude.MatchString(m.Message) {
+		return
+	}
+	if !sr.allow(remote, time.Now()) {
+		return
+	}
+
+	if err := sr.post(m); err != nil {
+		log.Printf("syslog: %s: %v", m.Hostname, err)
+	}
+}
+
+// post turns a message into an entry tagged with SYSLOG, the facility,
+// the host and the application. These tags are kept even with the tag
+// allow-list enabled, since no taxonomy can list every host and
+// application in advance.
+func (sr *SyslogReceiver) post(m SyslogMessage) error {
+	message := m.Hostname + " "
+	if m.AppName != "" {
+		message += m.AppName
+		if m.ProcID != "" {
+			message += "[" + m.ProcID + "]"
+		}
+		message += ": "
+	}
+	message += m.Message
+
+	tags := []string{"SYSLOG", m.FacilityName(), m.Hostname}
+	if m.AppName != "" {
+		tags = append(tags, m.AppName)
+	}
+
+	// Machine messages aren't searched for hashtags: a "#" there is more
+	// likely a number, as in "job #42 failed", than a tag.
+	tags, err := sr.s.lookupTags(tags, false)
+	if err != nil {
+		return err
+	}
+	u := newEntry(sr.user, message, tags)
+	u.Severity = entrySeverity(m.Severity)
+
+	if err := sr.s.insertUpdate(&u, sr.user.ID); err != nil {
+		return err
+	}
+	sr.s.publish(u)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogTagsSkipAllowList(t *testing.T) {
+	s := newTestServer(t, Config{TagAllowList: true})
+	sr, err := NewSyslogReceiver(s, SyslogConfig{Username: "SYSLOG", MinSeverity: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 9), Port: 514}
+	sr.receive(addr, []byte("<26>1 2026-10-18T12:00:00Z discovery ae35 42 - - unit failure predicted"))
+
+	u, err := s.getUpdate(1)
+	if err != nil {
+		t.Fatalf("syslog message was not posted: %v", err)
+	}
+	for _, tag := range []string{"SYSLOG", "DAEMON", "DISCOVERY", "AE35"} {
+		if !slices.Contains(u.Tags, tag) {
+			t.Errorf("tags %v are missing %s", u.Tags, tag)
+		}
+	}
+
+	sr.receive(addr, []byte("<26>1 2026-10-18T12:00:00Z discovery ae35 42 - - retry #3 failed"))
+	u, err = s.getUpdate(2)
+	if err != nil {
+		t.Fatalf("syslog message with a # was not posted: %v", err)
+	}
+	if slices.Contains(u.Tags, "3") {
+		t.Errorf("tags %v include the number after #", u.Tags)
+	}
+}
+
+func TestSyslogRejectsLongOctetCount(t *testing.T) {
+	s := newTestServer(t, Config{})
+	sr, err := NewSyslogReceiver(s, SyslogConfig{Username: "SYSLOG", MinSeverity: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close() // nolint:errcheck
+
+	done := make(chan struct{})
+	go func() {
+		sr.serveConn(server)
+		close(done)
+	}()
+	// A prefix that never ends must not be read to the end.
+	go client.Write([]byte(strings.Repeat("9", 4096))) // nolint:errcheck
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("connection with an overlong octet count was not closed")
+	}
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// DefaultTagPipeline is the normalization HAL has always applied to tags.
+const DefaultTagPipeline = "trim,upper,underscore"
+
+// tagNormalizer rewrites a single tag as one step of the normalization pipeline.
+type tagNormalizer func(string) string
+
+// tagNormalizers are the steps that can be named in the -tag-pipeline flag.
+var tagNormalizers = map[string]tagNormalizer{
+	"trim":       strings.TrimSpace,
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"underscore": func(tag string) string { return strings.ReplaceAll(tag, " ", "_") },
+	"dash":       func(tag string) string { return strings.ReplaceAll(tag, " ", "-") },
+	"alnum":      func(tag string) string { return nonTagCharPattern.ReplaceAllString(tag, "") },
+	"collapse":   func(tag string) string { return separatorRunPattern.ReplaceAllString(tag, "$1") },
+}
+
+var (
+	// nonTagCharPattern matches anything that is not allowed by the "alnum" step.
+	nonTagCharPattern = regexp.MustCompile(`[^\p{L}\p{N}_\-]+`)
+	// separatorRunPattern matches repeated separators squashed by the "collapse" step.
+	separatorRunPattern = regexp.MustCompile(`([_\-])[_\-]+`)
+)
+
+// ParseTagPipeline turns a comma-separated list of step names into a pipeline.
+func ParseTagPipeline(spec string) ([]tagNormalizer, error) {
+	var pipeline []tagNormalizer
+	for name := range strings.SplitSeq(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		step, ok := tagNormalizers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tag pipeline step %q", name)
+		}
+		pipeline = append(pipeline, step)
+	}
+	return pipeline, nil
+}
+
+// processTags takes a slice of tags and normalizes them with the given pipeline.
+// Empty and duplicate tags are dropped.
+func processTags(tags []string, pipeline []tagNormalizer) []string {
+	if len(tags) == 0 {
+		return tags
+	}
+
+	processed := make([]string, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		for _, step := range pipeline {
+			tag = step(tag)
+		}
+		tag = strings.TrimSpace(tag)
+		if tag == "" || strings.Contains(tag, ",") {
+			continue
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		processed = append(processed, tag)
+	}
+	return processed
+}
+
+// hashtagPattern matches #hashtags that start a word in a message.
+var hashtagPattern = regexp.MustCompile(`(^|\s)#([\p{L}\p{N}_\-]+)`)
+
+// extractHashtags returns the #hashtags found in msg (without the leading '#')
+// and the message with those hashtags removed.
+// If stripping would leave the message empty, the original message is returned.
+func extractHashtags(msg string) ([]string, string) {
+	matches := hashtagPattern.FindAllStringSubmatchIndex(msg, -1)
+	if len(matches) == 0 {
+		return nil, msg
+	}
+
+	tags := make([]string, 0, len(matches))
+	for _, m := range matches {
+		tags = append(tags, msg[m[4]:m[5]])
+	}
+
+	stripped := stripHashtags(msg, matches)
+	if strings.TrimSpace(stripped) == "" {
+		stripped = msg
+	}
+	return tags, stripped
+}
+
+// stripHashtags removes the matched hashtags along with the blanks around
+// them, leaving a single space where a hashtag separated two words. The
+// spacing elsewhere in the message is kept as it is.
+func stripHashtags(msg string, matches [][]int) string {
+	isBlank := func(c byte) bool { return c == ' ' || c == '\t' }
+
+	var b strings.Builder
+	pos := 0
+	for _, m := range matches {
+		// m[4]:m[5] is the tag; the '#' comes right before it.
+		start, end := m[4]-1, m[5]
+		for start > pos && isBlank(msg[start-1]) {
+			start--
+		}
+		for end < len(msg) && isBlank(msg[end]) {
+			end++
+		}
+		b.WriteString(msg[pos:start])
+		pos = end
+
+		out := b.String()
+		afterBlank := out == "" || strings.HasSuffix(out, "\n") || strings.HasSuffix(out, " ")
+		lineEnd := end == len(msg) || msg[end] == '\n'
+		if !afterBlank && !lineEnd {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteString(msg[pos:])
+	return b.String()
+}
+
+// splitTags turns the comma-separated tags column back into a slice.
+func splitTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
+
+// UnknownTagError is returned when the tag allow-list rejects a tag.
+type UnknownTagError struct {
+	Tag string
+}
+
+func (e *UnknownTagError) Error() string {
+	return fmt.Sprintf("unknown tag: %s", e.Tag)
+}
+
+// resolveTags normalizes tags and maps aliases to their canonical tag.
+// With the allow-list enabled, tags that are not in the taxonomy are rejected.
+func (s *Server) resolveTags(tags []string) ([]string, error) {
+	return s.lookupTags(tags, s.cfg.TagAllowList)
+}
+
+// lookupTags is resolveTags with the allow-list given by the caller.
+func (s *Server) lookupTags(tags []string, allowList bool) ([]string, error) {
+	tags = processTags(tags, s.cfg.TagPipeline)
+
+	resolved := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		var canonical string
+		err := s.db.QueryRow(ResolveTagQuery, tag).Scan(&canonical)
+		switch {
+		case err == nil:
+			tag = canonical
+		case errors.Is(err, sql.ErrNoRows):
+			if allowList {
+				return nil, &UnknownTagError{Tag: tag}
+			}
+		default:
+			return nil, err
+		}
+		resolved = append(resolved, tag)
+	}
+	return processTags(resolved, nil), nil
+}
+
+// normalizeTag runs a single tag through the pipeline, as used by the admin endpoints.
+func (s *Server) normalizeTag(tag string) string {
+	tags := processTags([]string{tag}, s.cfg.TagPipeline)
+	if len(tags) == 0 {
+		return ""
+	}
+	return tags[0]
+}
+
+// canonicalTag normalizes a tag taken from a URL or query and maps aliases
+// to their canonical tag, so lookups match what resolveTags stored.
+func (s *Server) canonicalTag(tag string) string {
+	tag = s.normalizeTag(tag)
+
+	var canonical string
+	if err := s.db.QueryRow(ResolveTagQuery, tag).Scan(&canonical); err == nil {
+		return canonical
+	}
+	return tag
+}
+
+// TaxonomyTag is a canonical tag along with the aliases that map to it.
+type TaxonomyTag struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+func (s *Server) handleTaxonomy(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(SelectTaxonomyQuery)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close() // nolint:errcheck
+
+	list := []TaxonomyTag{}
+	for rows.Next() {
+		var (
+			name    string
+			aliases sql.NullString
+		)
+		rows.Scan(&name, &aliases) // nolint:errcheck
+
+		t := TaxonomyTag{Name: name, Aliases: []string{}}
+		if aliases.Valid {
+			t.Aliases = splitTags(aliases.String)
+		}
+		list = append(list, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list) // nolint:errcheck
+}
+
+func (s *Server) handleCreateTag(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var in TaxonomyTag
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	name := s.normalizeTag(in.Name)
+	if name == "" {
+		http.Error(w, "tag name required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	if _, err := tx.Exec(InsertTagQuery, name); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			http.Error(w, "tag already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to create tag", http.StatusInternalServerError)
+		return
+	}
+
+	out := TaxonomyTag{Name: name, Aliases: []string{}}
+	for _, alias := range processTags(in.Aliases, s.cfg.TagPipeline) {
+		if alias == name {
+			continue
+		}
+		if _, err := tx.Exec(UpsertTagAliasQuery, alias, name); err != nil {
+			http.Error(w, "failed to create alias", http.StatusInternalServerError)
+			return
+		}
+		out.Aliases = append(out.Aliases, alias)
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(out) // nolint:errcheck
+}
+
+func (s *Server) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	name := s.normalizeTag(r.PathValue("tag"))
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	res, err := tx.Exec(DeleteTagQuery, name)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "tag not found", http.StatusNotFound)
+		return
+	}
+	if _, err := tx.Exec(DeleteAliasesForTagQuery, name); err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleCreateAlias(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	name := s.normalizeTag(r.PathValue("tag"))
+
+	var in struct {
+		Alias string `json:"alias"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	alias := s.normalizeTag(in.Alias)
+	if alias == "" || alias == name {
+		http.Error(w, "alias required", http.StatusBadRequest)
+		return
+	}
+
+	var exists int
+	if err := s.db.QueryRow(TagExistsQuery, name).Scan(&exists); err != nil {
+		http.Error(w, "tag not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := s.db.Exec(UpsertTagAliasQuery, alias, name); err != nil {
+		http.Error(w, "failed to create alias", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"alias": alias, "tag": name}) // nolint:errcheck
+}
+
+func (s *Server) handleDeleteAlias(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	res, err := s.db.Exec(DeleteTagAliasQuery, s.normalizeTag(r.PathValue("alias")))
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "alias not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleMergeTags(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var in struct {
+		From []string `json:"from"`
+		Into string   `json:"into"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	into := s.normalizeTag(in.Into)
+	from := processTags(in.From, s.cfg.TagPipeline)
+	if into == "" || len(from) == 0 {
+		http.Error(w, "from and into required", http.StatusBadRequest)
+		return
+	}
+
+	// Merged tags become aliases so new entries keep landing on the canonical tag.
+	updated, err := s.retag(from, into, true)
+	if err != nil {
+		http.Error(w, "failed to merge tags", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"tag": into, "merged": from, "entries_updated": updated}) // nolint:errcheck
+}
+
+func (s *Server) handleRenameTag(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var in struct {
+		To string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	name := s.normalizeTag(r.PathValue("tag"))
+	to := s.normalizeTag(in.To)
+	if name == "" || to == "" {
+		http.Error(w, "new tag name required", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := s.retag([]string{name}, to, false)
+	if errors.Is(err, errTagNotFound) {
+		http.Error(w, "tag not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to rename tag", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"tag": to, "renamed": name, "entries_updated": updated}) // nolint:errcheck
+}
+
+// errTagNotFound is returned by retag when renaming a tag that is neither in
+// the taxonomy nor on any entry.
+var errTagNotFound = errors.New("tag not found")
+
+// retag replaces the from tags with into on every existing entry and in the taxonomy.
+// If keepAliases is set, the from tags are recorded as aliases of into;
+// otherwise every from tag must exist.
+// It returns the number of entries that were rewritten.
+func (s *Server) retag(from []string, into string, keepAliases bool) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	if _, err := tx.Exec(InsertTagIgnoreQuery, into); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, old := range from {
+		if old == into {
+			continue
+		}
+
+		n, err := retagEntries(tx, old, into)
+		if err != nil {
+			return 0, err
+		}
+		updated += n
+
+		res, err := tx.Exec(DeleteTagQuery, old)
+		if err != nil {
+			return 0, err
+		}
+		if deleted, _ := res.RowsAffected(); deleted == 0 && n == 0 && !keepAliases {
+			return 0, errTagNotFound
+		}
+		if _, err := tx.Exec(RepointTagAliasesQuery, into, old); err != nil {
+			return 0, err
+		}
+		if keepAliases {
+			if _, err := tx.Exec(UpsertTagAliasQuery, old, into); err != nil {
+				return 0, err
+			}
+		} else if _, err := tx.Exec(DeleteTagAliasQuery, old); err != nil {
+			return 0, err
+		}
+	}
+	// into may previously have been an alias itself.
+	if _, err := tx.Exec(DeleteTagAliasQuery, into); err != nil {
+		return 0, err
+	}
+
+	return updated, tx.Commit()
+}
+
+// retagEntries rewrites the tags column of every entry tagged old.
+func retagEntries(tx *sql.Tx, old, into string) (int, error) {
+	rows, err := tx.Query(SelectEntriesWithTagQuery, old)
+	if err != nil {
+		return 0, err
+	}
+
+	type retagged struct {
+		id   int64
+		tags string
+	}
+	var changes []retagged
+
+	for rows.Next() {
+		var (
+			id   int64
+			tags string
+		)
+		if err := rows.Scan(&id, &tags); err != nil {
+			rows.Close() // nolint:errcheck
+			return 0, err
+		}
+
+		list := splitTags(tags)
+		for i, t := range list {
+			if t == old {
+				list[i] = into
+			}
+		}
+		changes = append(changes, retagged{id: id, tags: strings.Join(processTags(list, nil), ",")})
+	}
+	rows.Close() // nolint:errcheck
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, c := range changes {
+		if _, err := tx.Exec(UpdateEntryTagsQuery, c.tags, c.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(changes), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExtractHashtagsKeepsSpacing(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		tags []string
+		want string
+	}{
+		{
+			name: "between words",
+			msg:  "pod bay #doors stuck",
+			tags: []string{"doors"},
+			want: "pod bay stuck",
+		},
+		{
+			name: "at both ends",
+			msg:  "#ae35 unit failing #comms",
+			tags: []string{"ae35", "comms"},
+			want: "unit failing",
+		},
+		{
+			name: "next to each other",
+			msg:  "unit #ae35 #comms failing",
+			tags: []string{"ae35", "comms"},
+			want: "unit failing",
+		},
+		{
+			name: "aligned columns",
+			msg:  "pod    status\n1      open #doors\n2      closed",
+			tags: []string{"doors"},
+			want: "pod    status\n1      open\n2      closed",
+		},
+		{
+			name: "indented line",
+			msg:  "#deploy output:\n    step 1  ok",
+			tags: []string{"deploy"},
+			want: "output:\n    step 1  ok",
+		},
+		{
+			name: "only hashtags",
+			msg:  "#ae35 #comms",
+			tags: []string{"ae35", "comms"},
+			want: "#ae35 #comms",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, got := extractHashtags(tt.msg)
+			if !slices.Equal(tags, tt.tags) {
+				t.Errorf("tags %q, want %q", tags, tt.tags)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxTimelineDays caps the range a single timeline request may cover.
+const maxTimelineDays = 366
+
+// TagUserCount is the number of entries a user posted with a tag.
+type TagUserCount struct {
+	Username string `json:"username"`
+	Count    int    `json:"count"`
+}
+
+// TagStats summarises how a tag has been used over a date range.
+type TagStats struct {
+	Tag       string         `json:"tag"`
+	Count     int            `json:"count"`
+	FirstSeen string         `json:"first_seen"`
+	LastSeen  string         `json:"last_seen"`
+	TopUsers  []TagUserCount `json:"top_users"`
+
+	users map[string]int
+}
+
+// TagTimelineDay is the number of entries with a tag on a single day.
+type TagTimelineDay struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// TagTimeline is the per-day usage of a tag over a date range.
+type TagTimeline struct {
+	Tag   string           `json:"tag"`
+	From  string           `json:"from"`
+	To    string           `json:"to"`
+	Total int              `json:"total"`
+	Days  []TagTimelineDay `json:"days"`
+}
+
+// handleTags lists every tag used in the range with counts, first and last
+// use and the users who post it most. The range defaults to all time.
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDateRange(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	top := 3
+	if v := r.URL.Query().Get("top"); v != "" {
+		top, err = strconv.Atoi(v)
+		if err != nil || top < 0 {
+			http.Error(w, "invalid top", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := s.db.Query(SelectTaggedEntriesInRangeQuery, from.Format(dateLayout), to.Format(dateLayout))
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close() // nolint:errcheck
+
+	stats := map[string]*TagStats{}
+	for rows.Next() {
+		var (
+			username sql.NullString
+			tags     string
+			ts       string
+		)
+		rows.Scan(&username, &tags, &ts) // nolint:errcheck
+
+		for _, tag := range splitTags(tags) {
+			st, ok := stats[tag]
+			if !ok {
+				st = &TagStats{Tag: tag, FirstSeen: ts, users: map[string]int{}}
+				stats[tag] = st
+			}
+			st.Count++
+			st.LastSeen = ts
+			if username.Valid {
+				st.users[username.String]++
+			}
+		}
+	}
+
+	list := make([]*TagStats, 0, len(stats))
+	for _, st := range stats {
+		st.TopUsers = topTagUsers(st.users, top)
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Tag < list[j].Tag
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list) // nolint:errcheck
+}
+
+// topTagUsers returns the n users with the highest counts.
+func topTagUsers(users map[string]int, n int) []TagUserCount {
+	list := make([]TagUserCount, 0, len(users))
+	for username, count := range users {
+		list = append(list, TagUserCount{Username: username, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Username < list[j].Username
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// handleTagTimeline returns per-day counts for a tag, including days without
+// entries. The range defaults to the last 30 days.
+func (s *Server) handleTagTimeline(w http.ResponseWriter, r *http.Request) {
+	tag := s.canonicalTag(r.PathValue("tag"))
+	if tag == "" {
+		http.Error(w, "tag required", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseDateRange(r, 30)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to.Sub(from) > maxTimelineDays*24*time.Hour {
+		http.Error(w, "range too large", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := s.db.Query(SelectTagTimelineQuery, tag, from.Format(dateLayout), to.Format(dateLayout))
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close() // nolint:errcheck
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var (
+			day   string
+			count int
+		)
+		rows.Scan(&day, &count) // nolint:errcheck
+		counts[day] = count
+	}
+
+	timeline := TagTimeline{
+		Tag:  tag,
+		From: from.Format(dateLayout),
+		To:   to.Format(dateLayout),
+		Days: []TagTimelineDay{},
+	}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		day := d.Format(dateLayout)
+		timeline.Days = append(timeline.Days, TagTimelineDay{Date: day, Count: counts[day]})
+		timeline.Total += counts[day]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeline) // nolint:errcheck
+}
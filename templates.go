@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// templateFuncs are available to every page template.
+var templateFuncs = template.FuncMap{
+	"entryClass": entryClass,
+	"formatTime": formatTime,
+	"join":       strings.Join,
+}
+
+// loadTemplates parses every page template in dir.
+func loadTemplates(dir string) (*template.Template, error) {
+	return template.New("").Funcs(templateFuncs).ParseGlob(filepath.Join(dir, "*.html"))
+}
+
+// entryClass returns the CSS classes of an entry, matching createEntrySkeleton in app.js.
+func entryClass(u Update) string {
+	classes := []string{"entry"}
+	if u.Kind != "" && u.Kind != EntryKindLog {
+		classes = append(classes, u.Kind)
+	}
+	if u.Status != "" {
+		classes = append(classes, "blocker-"+u.Status)
+	}
+	if u.Severity != "" && u.Severity != SeverityInfo {
+		classes = append(classes, "severity-"+strings.ToLower(u.Severity))
+	}
+	if u.EscalationLevel > 0 {
+		classes = append(classes, "escalated")
+	}
+	if u.Ack != nil {
+		classes = append(classes, "acked")
+	}
+	return strings.Join(classes, " ")
+}
+
+// formatTime renders a stored timestamp in local time.
+func formatTime(ts string) string {
+	t := parseTimestamp(ts)
+	if t.IsZero() {
+		return ts
+	}
+	return t.In(time.Local).Format("2006-01-02 15:04:05")
+}
+
+// renderPage executes a page template, reporting failures before anything
+// has been written to the client.
+func (s *Server) renderPage(w http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+	if err := s.templates.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Printf("template %s: %v", name, err)
+		http.Error(w, "failed to render page", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes()) // nolint:errcheck
+}
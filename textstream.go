@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ANSI colors approximating the web UI palette.
+const (
+	ansiReset    = "\x1b[0m"
+	ansiTime     = "\x1b[33m"
+	ansiUsername = "\x1b[1;36m"
+	ansiMessage  = "\x1b[32m"
+	ansiTags     = "\x1b[2;32m"
+	ansiWarn     = "\x1b[1;33m"
+	ansiCrit     = "\x1b[1;31m"
+)
+
+// textFormatter renders entries as terminal lines, with or without color.
+type textFormatter struct {
+	color bool
+}
+
+func (f textFormatter) paint(code, s string) string {
+	if !f.color || s == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// sanitizeText drops control characters, such as escape sequences, that
+// users could otherwise send to every terminal following the stream.
+func sanitizeText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// formatEntry renders an entry like the web UI shows it:
+// "2006-01-02 15:04:05 [USER] message  tags: a, b". Continuation lines of
+// multi-line messages are indented under the message.
+func (f textFormatter) formatEntry(u Update) string {
+	var b strings.Builder
+
+	ts := formatTime(u.Timestamp)
+	b.WriteString(f.paint(ansiTime, ts))
+	b.WriteString(" ")
+	b.WriteString(f.paint(ansiUsername, "["+sanitizeText(u.Username)+"]"))
+	b.WriteString(" ")
+
+	msgColor := ansiMessage
+	switch u.Severity {
+	case SeverityWarn:
+		msgColor = ansiWarn
+	case SeverityCrit:
+		msgColor = ansiCrit
+	}
+	if u.Severity != "" && u.Severity != SeverityInfo {
+		b.WriteString(f.paint(msgColor, u.Severity))
+		b.WriteString(" ")
+	}
+	if u.Event != "" {
+		b.WriteString(f.paint(ansiWarn, "entry "+strconv.FormatInt(u.ID, 10)+" "+u.Event+":"))
+		b.WriteString(" ")
+	}
+
+	indent := strings.Repeat(" ", len(ts)+1)
+	lines := strings.Split(strings.TrimRight(sanitizeText(u.Message), "\n"), "\n")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\n")
+			b.WriteString(indent)
+		}
+		b.WriteString(f.paint(msgColor, line))
+	}
+
+	if len(u.Tags) > 0 {
+		b.WriteString("  ")
+		b.WriteString(f.paint(ansiTags, "tags: "+sanitizeText(strings.Join(u.Tags, ", "))))
+	}
+	return b.String()
+}
+
+// handleTextStream follows the log as plain text lines, so the stream can be
+// watched with curl -N. It accepts the same filters as /stream, and
+// ?color=false for output without ANSI colors.
+func (s *Server) handleTextStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "stream unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	filter, err := parseStreamFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f := textFormatter{color: true}
+	if v := r.URL.Query().Get("color"); v != "" {
+		f.color, err = strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "invalid color value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	clientCh := make(chan Update, 16)
+	s.addClient(clientCh)
+	defer s.removeClient(clientCh)
+
+	notify := r.Context().Done()
+
+	for {
+		select {
+		case <-notify:
+			return
+		case u := <-clientCh:
+			if !filter.match(u) {
+				continue
+			}
+			fmt.Fprintln(w, f.formatEntry(u)) // nolint:errcheck
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module hal_agent
+
+go 1.25.3
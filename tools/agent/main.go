@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule posts the lines matching a regular expression with the given tags
+// and severity.
+type Rule struct {
+	Match    string   `json:"match"`
+	Tags     []string `json:"tags"`
+	Severity string   `json:"severity"`
+
+	re *regexp.Regexp
+}
+
+// FileConfig is a file to follow and the rules for its lines. Without
+// rules every line is posted.
+type FileConfig struct {
+	Path  string `json:"path"`
+	Rules []Rule `json:"rules"`
+}
+
+// Config is the agent configuration file.
+type Config struct {
+	Addr          string       `json:"addr"`
+	User          string       `json:"user"`
+	Token         string       `json:"token"`
+	BatchSize     int          `json:"batch_size"`
+	FlushInterval string       `json:"flush_interval"`
+	PollInterval  string       `json:"poll_interval"`
+	FromStart     bool         `json:"from_start"`
+	Files         []FileConfig `json:"files"`
+}
+
+// match returns the first rule matching line, or nil.
+func (fc *FileConfig) match(line string) *Rule {
+	if len(fc.Rules) == 0 {
+		return &Rule{}
+	}
+	for i := range fc.Rules {
+		if fc.Rules[i].re.MatchString(line) {
+			return &fc.Rules[i]
+		}
+	}
+	return nil
+}
+
+func getTokenFilePath(username string) string {
+	homeDir, _ := os.UserHomeDir()
+	username = strings.ToUpper(strings.TrimSpace(username))
+	return filepath.Join(homeDir, ".hal", fmt.Sprintf("%s.token", username))
+}
+
+// loadToken reads the token saved by the client for username.
+func loadToken(username string) string {
+	data, err := os.ReadFile(getTokenFilePath(username))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func parseDuration(v string, def time.Duration) (time.Duration, error) {
+	if v == "" {
+		return def, nil
+	}
+	return time.ParseDuration(v)
+}
+
+func main() {
+	configPath := flag.String("config", "", "JSON configuration file")
+	addr := flag.String("addr", "", "Server address (host:port, default localhost:8080)")
+	user := flag.String("user", "", "Post as this user, with the token saved by the client in ~/.hal")
+	match := flag.String("match", "", "Only post lines matching this regular expression")
+	tags := flag.String("tags", "", "Comma-separated tags for posted lines")
+	severity := flag.String("severity", "", "Severity for posted lines (INFO, WARN, CRIT)")
+	fromStart := flag.Bool("from-start", false, "Read files from the beginning instead of only new lines")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: hal_agent [-config agent.json] [flags] [FILE ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg := &Config{}
+	if *configPath != "" {
+		var err error
+		if cfg, err = loadConfig(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Files given on the command line share the -match, -tags and -severity rule.
+	for _, path := range flag.Args() {
+		fc := FileConfig{Path: path}
+		if *match != "" || *tags != "" || *severity != "" {
+			rule := Rule{Match: *match, Severity: *severity}
+			for tag := range strings.SplitSeq(*tags, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					rule.Tags = append(rule.Tags, tag)
+				}
+			}
+			fc.Rules = []Rule{rule}
+		}
+		cfg.Files = append(cfg.Files, fc)
+	}
+	if *addr != "" {
+		cfg.Addr = *addr
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = "localhost:8080"
+	}
+	if *user != "" {
+		cfg.User = *user
+	}
+	if *fromStart {
+		cfg.FromStart = true
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 50
+	}
+
+	if len(cfg.Files) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	for i := range cfg.Files {
+		for j := range cfg.Files[i].Rules {
+			rule := &cfg.Files[i].Rules[j]
+			re, err := regexp.Compile(rule.Match)
+			if err != nil {
+				log.Fatalf("%s: invalid rule %q: %v", cfg.Files[i].Path, rule.Match, err)
+			}
+			rule.re = re
+		}
+	}
+
+	token := cfg.Token
+	if token == "" {
+		token = os.Getenv("HAL_TOKEN")
+	}
+	if token == "" && cfg.User != "" {
+		token = loadToken(cfg.User)
+	}
+	if token == "" {
+		log.Fatal("no token: run the client to register, then pass -user, or set HAL_TOKEN")
+	}
+
+	flushInterval, err := parseDuration(cfg.FlushInterval, 2*time.Second)
+	if err != nil {
+		log.Fatalf("invalid flush_interval: %v", err)
+	}
+	pollInterval, err := parseDuration(cfg.PollInterval, 500*time.Millisecond)
+	if err != nil {
+		log.Fatalf("invalid poll_interval: %v", err)
+	}
+
+	poster := NewPoster(fmt.Sprintf("http://%s", cfg.Addr), token, cfg.BatchSize, flushInterval)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := range cfg.Files {
+		fc := &cfg.Files[i]
+		t := &Tailer{Path: fc.Path, PollInterval: pollInterval, FromStart: cfg.FromStart}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.Follow(stop, func(line string) {
+				if rule := fc.match(line); rule != nil {
+					poster.Add(Item{Message: line, Tags: rule.Tags, Severity: rule.Severity})
+				}
+			})
+		}()
+		log.Printf("following %s", fc.Path)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		poster.Run(stop)
+		close(done)
+	}()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+
+	// Stop the tailers first so the lines they read last are still posted.
+	close(stop)
+	wg.Wait()
+	poster.Close()
+	<-done
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	timeoutDuration = 10 * time.Second
+
+	// maxBatchSize is the largest batch the server accepts.
+	maxBatchSize = 1000
+
+	// maxBackoff caps the delay between retries of a failed batch.
+	maxBackoff = time.Minute
+)
+
+// Item is one line to post, in the format of POST /update/batch.
+type Item struct {
+	Message  string   `json:"message"`
+	Tags     []string `json:"tags,omitempty"`
+	Severity string   `json:"severity,omitempty"`
+}
+
+// BatchResponse is the part of the server's batch response the agent reads.
+type BatchResponse struct {
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+	Results  []struct {
+		Index int    `json:"index"`
+		Error string `json:"error"`
+	} `json:"results"`
+}
+
+// Poster collects items and posts them in batches, retrying failed batches
+// with the same Idempotency-Key so a retry can never post a line twice.
+type Poster struct {
+	baseURL   string
+	token     string
+	batchSize int
+	interval  time.Duration
+	items     chan Item
+	client    *http.Client
+}
+
+func NewPoster(baseURL, token string, batchSize int, interval time.Duration) *Poster {
+	return &Poster{
+		baseURL:   baseURL,
+		token:     token,
+		batchSize: min(batchSize, maxBatchSize),
+		interval:  interval,
+		items:     make(chan Item, maxBatchSize),
+		client:    &http.Client{Timeout: timeoutDuration},
+	}
+}
+
+// Add queues an item. It blocks while the poster is behind, which holds
+// back the tailers instead of dropping lines.
+func (p *Poster) Add(it Item) {
+	p.items <- it
+}
+
+// Close stops accepting items; Run posts what is left and returns.
+func (p *Poster) Close() {
+	close(p.items)
+}
+
+// Run posts a batch whenever it is full or the flush interval has passed.
+// Once stop is closed, failed batches are no longer retried.
+func (p *Poster) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	var batch []Item
+	for {
+		select {
+		case it, ok := <-p.items:
+			if !ok {
+				if len(batch) > 0 {
+					p.send(batch, stop)
+				}
+				return
+			}
+			batch = append(batch, it)
+			if len(batch) >= p.batchSize {
+				p.send(batch, stop)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.send(batch, stop)
+				batch = nil
+			}
+		}
+	}
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b) // nolint:errcheck
+	return hex.EncodeToString(b)
+}
+
+// send posts a batch, retrying with exponential backoff on network and
+// server errors. Batches the server rejects outright are dropped.
+func (p *Poster) send(batch []Item, stop <-chan struct{}) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		log.Printf("failed to marshal batch: %v", err)
+		return
+	}
+
+	key := newIdempotencyKey()
+	backoff := time.Second
+	for {
+		retry, err := p.post(body, key)
+		if err == nil {
+			return
+		}
+		if !retry {
+			log.Printf("dropping %d lines: %v", len(batch), err)
+			return
+		}
+		log.Printf("posting %d lines failed, retrying in %s: %v", len(batch), backoff, err)
+
+		select {
+		case <-stop:
+			log.Printf("shutting down, %d lines not posted", len(batch))
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post sends one attempt and reports whether a failure is worth retrying.
+func (p *Poster) post(body []byte, key string) (bool, error) {
+	req, err := http.NewRequest("POST", p.baseURL+"/update/batch", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Auth-Token", p.token)
+	req.Header.Set("Idempotency-Key", key)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	data, _ := io.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusConflict, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return true, fmt.Errorf("server error %d: %s", resp.StatusCode, bytes.TrimSpace(data))
+	case resp.StatusCode >= 400:
+		return false, fmt.Errorf("rejected %d: %s", resp.StatusCode, bytes.TrimSpace(data))
+	}
+
+	var res BatchResponse
+	if err := json.Unmarshal(data, &res); err == nil && res.Rejected > 0 {
+		for _, r := range res.Results {
+			if r.Error != "" {
+				log.Printf("line rejected: %s", r.Error)
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Tailer follows a file like tail -F: it waits for the file to appear,
+// notices when it is rotated or truncated, and reopens it by name.
+type Tailer struct {
+	Path         string
+	PollInterval time.Duration
+
+	// FromStart reads the existing contents of the file the first time it
+	// is opened. Otherwise only lines written afterwards are reported.
+	FromStart bool
+
+	f       *os.File
+	r       *bufio.Reader
+	offset  int64
+	partial string
+}
+
+// open opens the file, seeking to its end when only new lines are wanted.
+func (t *Tailer) open(seekEnd bool) error {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	if seekEnd {
+		if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close() // nolint:errcheck
+			return err
+		}
+	}
+	t.f, t.r, t.offset, t.partial = f, bufio.NewReader(f), offset, ""
+	return nil
+}
+
+// drain reports every complete line available. A trailing line without a
+// newline is kept until the rest of it is written.
+func (t *Tailer) drain(emit func(string)) {
+	for {
+		line, err := t.r.ReadString('\n')
+		t.offset += int64(len(line))
+		if err != nil {
+			t.partial += line
+			return
+		}
+
+		line = strings.TrimRight(t.partial+line, "\r\n")
+		t.partial = ""
+		if strings.TrimSpace(line) != "" {
+			emit(line)
+		}
+	}
+}
+
+// close reports a pending partial line and closes the file.
+func (t *Tailer) close(emit func(string)) {
+	if strings.TrimSpace(t.partial) != "" {
+		emit(strings.TrimRight(t.partial, "\r\n"))
+	}
+	t.f.Close() // nolint:errcheck
+	t.f = nil
+}
+
+// Follow calls emit for every new line until stop is closed.
+func (t *Tailer) Follow(stop <-chan struct{}, emit func(string)) {
+	first := true
+	for {
+		if t.f == nil {
+			// Only a file that exists at startup has contents to skip; one
+			// created later is read from its start.
+			err := t.open(first && !t.FromStart)
+			first = false
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("%s: %v", t.Path, err)
+			}
+		}
+
+		if t.f != nil {
+			t.drain(emit)
+
+			current, _ := t.f.Stat()
+			info, err := os.Stat(t.Path)
+			switch {
+			case err != nil:
+				// Removed; keep the old file until a new one appears.
+			case current != nil && !os.SameFile(info, current):
+				// Rotated: finish the old file, then read the new one from the start.
+				t.drain(emit)
+				t.close(emit)
+				continue
+			case info.Size() < t.offset:
+				// Truncated in place.
+				if _, err := t.f.Seek(0, io.SeekStart); err == nil {
+					t.r.Reset(t.f)
+					t.offset, t.partial = 0, ""
+				}
+			}
+		}
+
+		select {
+		case <-stop:
+			if t.f != nil {
+				t.drain(emit)
+				t.close(emit)
+			}
+			return
+		case <-time.After(t.PollInterval):
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// hookTimeout keeps a slow or unreachable server from holding up git.
+const hookTimeout = 3 * time.Second
+
+// hookMarker identifies the hooks written by the client, so they can be
+// updated and removed without touching hooks installed by anything else.
+const hookMarker = "# installed by h_comms"
+
+// hookNames are the hooks the client installs. Git has no post-push hook,
+// so pushes are posted from pre-push; the hook never stops a push.
+var hookNames = []string{"post-commit", "pre-push"}
+
+// zeroSHA is the object name git uses for refs that don't exist.
+var zeroSHA = strings.Repeat("0", 40)
+
+// git runs a git command in repo and returns its trimmed output.
+func git(repo string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitConfig returns a value from the repository's git config, or "" if unset.
+func gitConfig(repo, key string) string {
+	v, _ := git(repo, "config", "--get", key)
+	return v
+}
+
+func hookPath(repo, name string) (string, error) {
+	dir, err := git(repo, "rev-parse", "--path-format=absolute", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func hookScript(exe, name string) string {
+	return fmt.Sprintf("#!/bin/sh\n%s: posts to HAL. Opt out with: h_comms hook disable\n%q hook run %s \"$@\" || true\n",
+		hookMarker, exe, name)
+}
+
+// isOurHook reports whether the hook at path was written by the client.
+func isOurHook(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(data), hookMarker), nil
+}
+
+func installHooks(repo, addr, user string) error {
+	if loadToken(user) == "" {
+		return fmt.Errorf("no token saved for %s; register with the client first", strings.ToUpper(user))
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range hookNames {
+		path, err := hookPath(repo, name)
+		if err != nil {
+			return err
+		}
+		ours, err := isOurHook(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && !ours {
+			return fmt.Errorf("%s already exists; add this line to it instead:\n%q hook run %s \"$@\" || true", path, exe, name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(hookScript(exe, name)), 0755); err != nil {
+			return err
+		}
+		fmt.Println("installed", path)
+	}
+
+	if _, err := git(repo, "config", "hal.user", strings.ToUpper(strings.TrimSpace(user))); err != nil {
+		return err
+	}
+	if _, err := git(repo, "config", "hal.addr", addr); err != nil {
+		return err
+	}
+	git(repo, "config", "--unset", "hal.disabled") // nolint:errcheck
+	return nil
+}
+
+func uninstallHooks(repo string) error {
+	for _, name := range hookNames {
+		path, err := hookPath(repo, name)
+		if err != nil {
+			return err
+		}
+		ours, err := isOurHook(path)
+		if err != nil || !ours {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		fmt.Println("removed", path)
+	}
+
+	git(repo, "config", "--remove-section", "hal") // nolint:errcheck
+	return nil
+}
+
+// postHookUpdate posts an update as the user configured for the repository.
+func postHookUpdate(repo, message string, tags []string) error {
+	user := gitConfig(repo, "hal.user")
+	if user == "" {
+		return errors.New("hal.user is not set; run h_comms hook install")
+	}
+	token := loadToken(user)
+	if token == "" {
+		return fmt.Errorf("no token saved for %s", user)
+	}
+	addr := gitConfig(repo, "hal.addr")
+	if addr == "" {
+		addr = "localhost:8080"
+	}
+
+	jsonData, err := json.Marshal(RequestPayload{Message: message, Tags: tags})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: hookTimeout}
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/update", addr), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Auth-Token", token)
+	req.Header.Set("Idempotency-Key", newIdempotencyKey())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server error: %s", strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// repoName names the repository after its top-level directory.
+func repoName(repo string) string {
+	top, err := git(repo, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(top)
+}
+
+// rebasing reports whether a rebase is replaying commits, whose post-commit
+// hooks would otherwise post every rewritten commit again.
+func rebasing(repo string) bool {
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		path, err := git(repo, "rev-parse", "--path-format=absolute", "--git-path", dir)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hookTags tags an update with the repository and branch, plus kind.
+func hookTags(repo, branch, kind string) []string {
+	tags := []string{kind}
+	if name := repoName(repo); name != "" {
+		tags = append(tags, name)
+	}
+	if branch != "" && branch != "HEAD" {
+		tags = append(tags, branch)
+	}
+	return tags
+}
+
+// runPostCommit posts the summary of the commit just made.
+func runPostCommit(repo string) error {
+	if rebasing(repo) {
+		return nil
+	}
+	summary, err := git(repo, "log", "-1", "--format=%h %s")
+	if err != nil {
+		return err
+	}
+	branch, _ := git(repo, "rev-parse", "--abbrev-ref", "HEAD")
+
+	hash, subject, _ := strings.Cut(summary, " ")
+	return postHookUpdate(repo, fmt.Sprintf("%s (%s)", subject, hash), hookTags(repo, branch, "commit"))
+}
+
+// runPrePush posts one update per pushed branch, listing its new commits.
+// Git passes the remote name and the refs being pushed on stdin as
+// "<local ref> <local sha> <remote ref> <remote sha>" lines.
+func runPrePush(repo, remote string, stdin io.Reader) error {
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || fields[1] == zeroSHA {
+			continue // deleting a remote branch
+		}
+		localSHA, remoteRef, remoteSHA := fields[1], fields[2], fields[3]
+
+		// A new branch, or one whose remote tip we haven't fetched, lists
+		// the commits that no remote-tracking branch has yet.
+		args := []string{"log", "--format=%h %s", localSHA}
+		if _, err := git(repo, "cat-file", "-e", remoteSHA+"^{commit}"); remoteSHA == zeroSHA || err != nil {
+			args = append(args, "--not", "--remotes="+remote)
+		} else {
+			args = append(args, "^"+remoteSHA)
+		}
+		out, err := git(repo, args...)
+		if err != nil {
+			return err
+		}
+		if out == "" {
+			continue
+		}
+
+		commits := strings.Split(out, "\n")
+		branch := strings.TrimPrefix(remoteRef, "refs/heads/")
+		noun := "commits"
+		if len(commits) == 1 {
+			noun = "commit"
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "pushed %d %s to %s/%s", len(commits), noun, remote, branch)
+		for _, c := range commits {
+			b.WriteString("\n- " + c)
+		}
+		if err := postHookUpdate(repo, b.String(), hookTags(repo, branch, "push")); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func runHook(repo, name string, args []string) error {
+	if disabled, _ := git(repo, "config", "--type=bool", "--get", "hal.disabled"); disabled == "true" {
+		return nil
+	}
+	switch name {
+	case "post-commit":
+		return runPostCommit(repo)
+	case "pre-push":
+		if len(args) == 0 {
+			return errors.New("pre-push: missing remote name")
+		}
+		return runPrePush(repo, args[0], os.Stdin)
+	}
+	return fmt.Errorf("unknown hook %q", name)
+}
+
+func hookUsage(fs *flag.FlagSet) func() {
+	return func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "usage: h_comms hook install -user NAME [-addr host:port] [REPO]")
+		fmt.Fprintln(out, "       h_comms hook uninstall [REPO]")
+		fmt.Fprintln(out, "       h_comms hook disable|enable [REPO]")
+		fs.PrintDefaults()
+	}
+}
+
+// runHookCommand implements "h_comms hook", which posts commits and pushes
+// of a repository to HAL.
+func runHookCommand(args []string) {
+	fs := flag.NewFlagSet("hook", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "Server address (host:port)")
+	user := fs.String("user", "", "Post as this user, with the token saved in ~/.hal")
+	fs.Usage = hookUsage(fs)
+
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	action := args[0]
+	fs.Parse(args[1:]) // nolint:errcheck
+
+	repo := "."
+	if action != "run" && fs.NArg() > 0 {
+		repo = fs.Arg(0)
+	}
+
+	var err error
+	switch action {
+	case "install":
+		if *user == "" {
+			fs.Usage()
+			os.Exit(2)
+		}
+		err = installHooks(repo, *addr, *user)
+	case "uninstall":
+		err = uninstallHooks(repo)
+	case "disable":
+		_, err = git(repo, "config", "hal.disabled", "true")
+	case "enable":
+		_, err = git(repo, "config", "hal.disabled", "false")
+	case "run":
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		// Hooks must never get in the way of git, so failures are only reported.
+		if err := runHook(repo, fs.Arg(0), fs.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "h_comms: %v\n", err)
+		}
+		return
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "h_comms: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -18,18 +20,41 @@ import (
 	"github.com/charmbracelet/lipgloss"
 )
 
-var (
-	focusedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#00ffc3"))
-	blurredStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#828987ff"))
-	cursorStyle  = focusedStyle
-	noStyle      = lipgloss.NewStyle()
-	helpStyle    = blurredStyle
-	successStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#00ff95"))
-	errorStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#ff5555"))
-
-	focusedButton = focusedStyle.Render("[ Submit ]")
-	blurredButton = fmt.Sprintf("[ %s ]", blurredStyle.Render("Submit"))
-)
+// styles are built from a renderer so that SSH sessions are styled for the
+// remote terminal rather than the one the server runs in.
+type styles struct {
+	focused lipgloss.Style
+	blurred lipgloss.Style
+	cursor  lipgloss.Style
+	none    lipgloss.Style
+	help    lipgloss.Style
+	success lipgloss.Style
+	failure lipgloss.Style
+
+	// Severity colors of feed entries, as in the web UI.
+	warn lipgloss.Style
+	crit lipgloss.Style
+
+	focusedButton string
+	blurredButton string
+}
+
+func newStyles(r *lipgloss.Renderer) styles {
+	st := styles{
+		focused: r.NewStyle().Foreground(lipgloss.Color("#00ffc3")),
+		blurred: r.NewStyle().Foreground(lipgloss.Color("#828987ff")),
+		none:    r.NewStyle(),
+		success: r.NewStyle().Foreground(lipgloss.Color("#00ff95")),
+		failure: r.NewStyle().Foreground(lipgloss.Color("#ff5555")),
+		warn:    r.NewStyle().Foreground(lipgloss.Color("#ffb000")),
+		crit:    r.NewStyle().Foreground(lipgloss.Color("#ff5555")).Bold(true),
+	}
+	st.cursor = st.focused
+	st.help = st.blurred
+	st.focusedButton = st.focused.Render("[ Submit ]")
+	st.blurredButton = fmt.Sprintf("[ %s ]", st.blurred.Render("Submit"))
+	return st
+}
 
 const timeoutDuration = 10 * time.Second
 
@@ -70,6 +95,33 @@ type model struct {
 	token      string
 	response   *ResponseMsg
 	submitting bool
+
+	// pending is the idempotency key of a message that hasn't been
+	// confirmed yet, so resubmitting it after a timeout can't duplicate it.
+	pending *pendingSubmit
+
+	st    styles
+	width int
+
+	// remote is set for SSH sessions. They are logged in as the owner of
+	// their key, so they can't switch users and keep no token on disk.
+	remote bool
+
+	// feed holds the latest entries of the crew log, as read from entries.
+	feed    []Entry
+	entries <-chan Entry
+}
+
+type pendingSubmit struct {
+	key     string
+	message string
+	tags    string
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b) // nolint:errcheck
+	return hex.EncodeToString(b)
 }
 
 func getTokenFilePath(username string) string {
@@ -98,11 +150,13 @@ func loadToken(username string) string {
 	return strings.TrimSpace(string(data))
 }
 
-func initialModel(baseURL string) model {
+func initialModel(baseURL string, st styles, entries <-chan Entry) model {
 	m := model{
 		mode:    ModeCreateUser,
 		baseURL: baseURL,
 		token:   "",
+		st:      st,
+		entries: entries,
 	}
 
 	m.setupInputs()
@@ -116,8 +170,8 @@ func (m *model) setupInputs() {
 		t := textinput.New()
 		t.Placeholder = "Enter username..."
 		t.Focus()
-		t.PromptStyle = focusedStyle
-		t.TextStyle = focusedStyle
+		t.PromptStyle = m.st.focused
+		t.TextStyle = m.st.focused
 		t.CharLimit = 50
 		t.Width = 50
 		m.inputs[0] = t
@@ -125,14 +179,14 @@ func (m *model) setupInputs() {
 		m.inputs = make([]textinput.Model, 2)
 		for i := range m.inputs {
 			t := textinput.New()
-			t.Cursor.Style = cursorStyle
+			t.Cursor.Style = m.st.cursor
 
 			switch i {
 			case 0:
 				t.Placeholder = "Enter your message..."
 				t.Focus()
-				t.PromptStyle = focusedStyle
-				t.TextStyle = focusedStyle
+				t.PromptStyle = m.st.focused
+				t.TextStyle = m.st.focused
 				t.CharLimit = 500
 				t.Width = 50
 			case 1:
@@ -147,7 +201,7 @@ func (m *model) setupInputs() {
 }
 
 func (m model) Init() tea.Cmd {
-	return textinput.Blink
+	return tea.Batch(textinput.Blink, waitForEntry(m.entries))
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -158,7 +212,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Quit
 
 		case "backspace":
-			if m.mode == ModePostMessage {
+			if m.mode == ModePostMessage && !m.remote {
 				if m.focusIndex < len(m.inputs) && m.inputs[m.focusIndex].Value() == "" {
 					m.mode = ModeCreateUser
 					m.token = ""
@@ -190,8 +244,12 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						return m, createUser(m.baseURL, username)
 					}
 				} else if m.mode == ModePostMessage && len(m.inputs) > 0 && m.inputs[0].Value() != "" {
+					message, tags := m.inputs[0].Value(), m.inputs[1].Value()
+					if m.pending == nil || m.pending.message != message || m.pending.tags != tags {
+						m.pending = &pendingSubmit{key: newIdempotencyKey(), message: message, tags: tags}
+					}
 					m.submitting = true
-					return m, submitMessage(m.baseURL, m.inputs[0].Value(), m.inputs[1].Value(), m.token)
+					return m, submitMessage(m.baseURL, message, tags, m.token, m.pending.key)
 				}
 				return m, nil
 			}
@@ -213,19 +271,29 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if i == m.focusIndex {
 					// Set focused
 					cmds[i] = m.inputs[i].Focus()
-					m.inputs[i].PromptStyle = focusedStyle
-					m.inputs[i].TextStyle = focusedStyle
+					m.inputs[i].PromptStyle = m.st.focused
+					m.inputs[i].TextStyle = m.st.focused
 					continue
 				}
 				// Remove focused
 				m.inputs[i].Blur()
-				m.inputs[i].PromptStyle = noStyle
-				m.inputs[i].TextStyle = noStyle
+				m.inputs[i].PromptStyle = m.st.none
+				m.inputs[i].TextStyle = m.st.none
 			}
 
 			return m, tea.Batch(cmds...)
 		}
 
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+
+	case EntryMsg:
+		m.feed = append(m.feed, Entry(msg))
+		if len(m.feed) > feedSize {
+			m.feed = m.feed[len(m.feed)-feedSize:]
+		}
+		return m, waitForEntry(m.entries)
+
 	case ResponseMsg:
 		m.submitting = false
 		m.response = &msg
@@ -258,6 +326,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		if msg.err == nil {
+			m.pending = nil
 			if len(m.inputs) > 0 {
 				m.inputs[0].SetValue("")
 			}
@@ -267,8 +336,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.focusIndex = 0
 			if len(m.inputs) > 0 {
 				m.inputs[0].Focus()
-				m.inputs[0].PromptStyle = focusedStyle
-				m.inputs[0].TextStyle = focusedStyle
+				m.inputs[0].PromptStyle = m.st.focused
+				m.inputs[0].TextStyle = m.st.focused
 			}
 		}
 		return m, nil
@@ -291,74 +360,87 @@ func (m *model) updateInputs(msg tea.Msg) tea.Cmd {
 func (m model) View() string {
 	var b strings.Builder
 
-	b.WriteString(focusedStyle.Render("HAL: Status Report, Dave!") + "\n\n")
+	b.WriteString(m.st.focused.Render("HAL: Status Report, Dave!") + "\n\n")
 
 	switch m.mode {
 	case ModeCreateUser:
-		b.WriteString(helpStyle.Render("Enter Username:") + "\n\n")
+		b.WriteString(m.st.help.Render("Enter Username:") + "\n\n")
 		if len(m.inputs) > 0 {
-			b.WriteString(helpStyle.Render("Username:") + "\n")
+			b.WriteString(m.st.help.Render("Username:") + "\n")
 			b.WriteString(m.inputs[0].View())
 			b.WriteString("\n\n")
 		}
 
-		button := &blurredButton
+		button := m.st.blurredButton
 		if m.focusIndex == len(m.inputs) {
-			button = &focusedButton
+			button = m.st.focusedButton
 		}
-		fmt.Fprintf(&b, "%s\n\n", *button)
+		fmt.Fprintf(&b, "%s\n\n", button)
 
 	case ModePostMessage:
-		b.WriteString(helpStyle.Render("Post Message:") + "\n\n")
+		b.WriteString(m.st.help.Render("Crew Log:") + "\n")
+		if len(m.feed) == 0 {
+			b.WriteString(m.st.help.Render("No entries today.") + "\n")
+		}
+		for _, e := range m.feed {
+			b.WriteString(m.st.formatEntry(e, m.width) + "\n")
+		}
+		b.WriteString("\n")
+
+		b.WriteString(m.st.help.Render("Post Message:") + "\n\n")
 		if len(m.inputs) > 0 {
-			b.WriteString(helpStyle.Render("Message:") + "\n")
+			b.WriteString(m.st.help.Render("Message:") + "\n")
 			b.WriteString(m.inputs[0].View())
 			b.WriteString("\n\n")
 		}
 		if len(m.inputs) > 1 {
-			b.WriteString(helpStyle.Render("Tags:") + "\n")
+			b.WriteString(m.st.help.Render("Tags:") + "\n")
 			b.WriteString(m.inputs[1].View())
 			b.WriteString("\n\n")
 		}
 
-		button := &blurredButton
+		button := m.st.blurredButton
 		if m.focusIndex == len(m.inputs) {
-			button = &focusedButton
+			button = m.st.focusedButton
 		}
-		fmt.Fprintf(&b, "%s\n\n", *button)
+		fmt.Fprintf(&b, "%s\n\n", button)
 	}
 
 	if m.submitting {
-		b.WriteString(helpStyle.Render("Submitting...") + "\n\n")
+		b.WriteString(m.st.help.Render("Submitting...") + "\n\n")
 	}
 
 	if m.response != nil {
 		if m.response.err != nil {
-			b.WriteString(errorStyle.Render(fmt.Sprintf("Error: %v", m.response.err)) + "\n\n")
+			b.WriteString(m.st.failure.Render(fmt.Sprintf("Error: %v", m.response.err)) + "\n\n")
 		} else {
-			b.WriteString(successStyle.Render(fmt.Sprintf("Success! Status: %d", m.response.statusCode)) + "\n")
+			b.WriteString(m.st.success.Render(fmt.Sprintf("Success! Status: %d", m.response.statusCode)) + "\n")
 			if m.response.body != "" {
-				b.WriteString(helpStyle.Render(fmt.Sprintf("Response: %s", m.response.body)) + "\n")
+				b.WriteString(m.st.help.Render(fmt.Sprintf("Response: %s", m.response.body)) + "\n")
 			}
 			if m.response.userToken != "" {
-				b.WriteString(successStyle.Render("Token saved locally!") + "\n")
+				b.WriteString(m.st.success.Render("Token saved locally!") + "\n")
 			}
 			b.WriteString("\n")
 		}
 	}
 
-	b.WriteString(helpStyle.Render(fmt.Sprintf("Endpoint: %s", m.baseURL)) + "\n")
+	b.WriteString(m.st.help.Render(fmt.Sprintf("Endpoint: %s", m.baseURL)) + "\n")
 	if m.token != "" {
-		b.WriteString(helpStyle.Render("Auth: Token configured") + "\n")
+		b.WriteString(m.st.help.Render("Auth: Token configured") + "\n")
 	} else {
-		b.WriteString(errorStyle.Render("Auth: No token") + "\n")
+		b.WriteString(m.st.failure.Render("Auth: No token") + "\n")
 	}
 
 	switch m.mode {
 	case ModeCreateUser:
-		b.WriteString(helpStyle.Render("tab/shift+tab: navigate • enter: submit • esc: quit") + "\n")
+		b.WriteString(m.st.help.Render("tab/shift+tab: navigate • enter: submit • esc: quit") + "\n")
 	case ModePostMessage:
-		b.WriteString(helpStyle.Render("backspace: back to username • tab/shift+tab: navigate • enter: submit • esc: quit") + "\n")
+		if m.remote {
+			b.WriteString(m.st.help.Render("tab/shift+tab: navigate • enter: submit • esc: quit") + "\n")
+		} else {
+			b.WriteString(m.st.help.Render("backspace: back to username • tab/shift+tab: navigate • enter: submit • esc: quit") + "\n")
+		}
 	}
 
 	return b.String()
@@ -420,7 +502,7 @@ func createUser(baseURL, username string) tea.Cmd {
 	}
 }
 
-func submitMessage(baseURL, message, tagsStr, token string) tea.Cmd {
+func submitMessage(baseURL, message, tagsStr, token, idempotencyKey string) tea.Cmd {
 	return func() tea.Msg {
 		var tags []string
 		if tagsStr != "" {
@@ -455,6 +537,9 @@ func submitMessage(baseURL, message, tagsStr, token string) tea.Cmd {
 		if token != "" {
 			req.Header.Set("X-Auth-Token", token)
 		}
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
 
 		resp, err := client.Do(req)
 		if err != nil {
@@ -486,12 +571,22 @@ func submitMessage(baseURL, message, tagsStr, token string) tea.Cmd {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "hook" {
+		runHookCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ssh" {
+		runSSHCommand(os.Args[2:])
+		return
+	}
+
 	addr := flag.String("addr", "localhost:8080", "Server address (host:port)")
 	flag.Parse()
 
 	baseURL := fmt.Sprintf("http://%s", *addr)
 
-	p := tea.NewProgram(initialModel(baseURL))
+	st := newStyles(lipgloss.DefaultRenderer())
+	p := tea.NewProgram(initialModel(baseURL, st, followStream(baseURL, nil)))
 	if _, err := p.Run(); err != nil {
 		log.Fatalf("Error running program: %s\n", err)
 	}
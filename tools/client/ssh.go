@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish"
+	"github.com/charmbracelet/wish/activeterm"
+	"github.com/charmbracelet/wish/bubbletea"
+	"github.com/charmbracelet/wish/logging"
+)
+
+// authorizedKey formats a public key like a line of authorized_keys.
+func authorizedKey(key ssh.PublicKey) string {
+	return key.Type() + " " + base64.StdEncoding.EncodeToString(key.Marshal())
+}
+
+// authorizeKey asks the server which user a public key is registered to.
+func authorizeKey(baseURL, adminToken string, key ssh.PublicKey) (*CreateUserResponse, error) {
+	jsonData, err := json.Marshal(map[string]string{"public_key": authorizedKey(key)})
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: timeoutDuration}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/admin/ssh/authorize", baseURL), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Admin-Token", adminToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("server error: %s", strings.TrimSpace(string(body)))
+	}
+
+	var user CreateUserResponse
+	if err := json.Unmarshal(body, &user); err != nil {
+		return nil, err
+	}
+	if user.Token == "" {
+		return nil, errors.New("server returned no token")
+	}
+	return &user, nil
+}
+
+// remoteModel is the model of an SSH session, logged in as user.
+func remoteModel(baseURL string, user *CreateUserResponse, st styles, entries <-chan Entry) model {
+	m := initialModel(baseURL, st, entries)
+	m.remote = true
+	m.mode = ModePostMessage
+	m.token = user.Token
+	m.setupInputs()
+	return m
+}
+
+// runSSHCommand implements "h_comms ssh", which serves the client over SSH
+// to crew who log in with a public key registered with the server.
+func runSSHCommand(args []string) {
+	homeDir, _ := os.UserHomeDir()
+
+	fs := flag.NewFlagSet("ssh", flag.ExitOnError)
+	listen := fs.String("listen", ":2222", "SSH listen address")
+	addr := fs.String("addr", "localhost:8080", "Server address (host:port)")
+	hostKey := fs.String("host-key", filepath.Join(homeDir, ".hal", "ssh_host_ed25519"), "SSH host key, created if missing")
+	adminToken := fs.String("admin-token", os.Getenv("HAL_ADMIN_TOKEN"), "Server admin token, used to look up keys (default $HAL_ADMIN_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: h_comms ssh [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) // nolint:errcheck
+
+	if *adminToken == "" {
+		log.Fatal("an admin token is required to look up keys: pass -admin-token or set HAL_ADMIN_TOKEN")
+	}
+	baseURL := fmt.Sprintf("http://%s", *addr)
+
+	srv, err := wish.NewServer(
+		wish.WithAddress(*listen),
+		wish.WithHostKeyPath(*hostKey),
+		// The callback also runs for keys the client only offers without
+		// proving it holds them, so it just says whether a key is
+		// registered. The session looks up its user from the key it
+		// was actually authenticated with.
+		wish.WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+			if _, err := authorizeKey(baseURL, *adminToken, key); err != nil {
+				log.Printf("ssh: %s: %v", ctx.RemoteAddr(), err)
+				return false
+			}
+			return true
+		}),
+		wish.WithMiddleware(
+			bubbletea.Middleware(func(sess ssh.Session) (tea.Model, []tea.ProgramOption) {
+				user, err := authorizeKey(baseURL, *adminToken, sess.PublicKey())
+				if err != nil {
+					log.Printf("ssh: %s: %v", sess.RemoteAddr(), err)
+					wish.Fatalln(sess, "login failed, try again")
+					return nil, nil
+				}
+				st := newStyles(bubbletea.MakeRenderer(sess))
+				m := remoteModel(baseURL, user, st, followStream(baseURL, sess.Context().Done()))
+				return m, []tea.ProgramOption{tea.WithAltScreen()}
+			}),
+			activeterm.Middleware(),
+			logging.Middleware(),
+		),
+	)
+	if err != nil {
+		log.Fatalf("ssh: %v", err)
+	}
+
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		<-c
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx) // nolint:errcheck
+	}()
+
+	log.Printf("Serving the client over SSH on %s for %s", *listen, baseURL)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
+		log.Fatalf("ssh: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// feedSize is the number of entries shown from the crew log.
+const feedSize = 10
+
+// reconnectDelay is how long to wait before reconnecting to the stream.
+const reconnectDelay = 3 * time.Second
+
+// Entry is a log entry as sent by the server.
+type Entry struct {
+	ID        int64    `json:"id"`
+	Username  string   `json:"username"`
+	Message   string   `json:"message"`
+	Tags      []string `json:"tags"`
+	Timestamp string   `json:"timestamp"`
+	Severity  string   `json:"severity"`
+	Event     string   `json:"event"`
+}
+
+type EntryMsg Entry
+
+// followStream sends today's latest entries and then every new one until
+// done is closed, reconnecting to the server whenever the stream drops.
+func followStream(baseURL string, done <-chan struct{}) <-chan Entry {
+	ch := make(chan Entry, 64)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if done != nil {
+		go func() {
+			<-done
+			cancel()
+		}()
+	}
+
+	send := func(e Entry) bool {
+		select {
+		case ch <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer cancel()
+
+		entries, _ := fetchInitial(ctx, baseURL)
+		for _, e := range entries[max(0, len(entries)-feedSize):] {
+			if !send(e) {
+				return
+			}
+		}
+
+		for {
+			readStream(ctx, baseURL, send) // nolint:errcheck
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(reconnectDelay):
+			}
+		}
+	}()
+	return ch
+}
+
+func fetchInitial(ctx context.Context, baseURL string) ([]Entry, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/initial", baseURL), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: timeoutDuration}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	var entries []Entry
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	return entries, err
+}
+
+// readStream reads new entries from the event stream until it ends. Events
+// about existing entries, such as acknowledgements, are skipped.
+func readStream(ctx context.Context, baseURL string, send func(Entry) bool) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/stream", baseURL), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(data), &e); err != nil || e.Event != "" {
+			continue
+		}
+		if !send(e) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// waitForEntry delivers the next entry to the model.
+func waitForEntry(ch <-chan Entry) tea.Cmd {
+	if ch == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		e, ok := <-ch
+		if !ok {
+			return nil
+		}
+		return EntryMsg(e)
+	}
+}
+
+// formatEntry renders an entry on one line, "15:04 [USER] message #TAG",
+// colored by severity and cut to width.
+func (st styles) formatEntry(e Entry, width int) string {
+	stamp := "--:--"
+	if t, err := time.Parse(time.RFC3339, e.Timestamp); err == nil {
+		stamp = t.Local().Format("15:04")
+	}
+
+	text := strings.Join(strings.Fields(e.Message), " ")
+	for _, tag := range e.Tags {
+		text += " #" + tag
+	}
+
+	prefix := stamp + " [" + e.Username + "] "
+	if width > 0 {
+		if room := width - len([]rune(prefix)); room <= 1 {
+			text = ""
+		} else if r := []rune(text); len(r) > room {
+			text = string(r[:room-1]) + "…"
+		}
+	}
+
+	style := st.none
+	switch e.Severity {
+	case "WARN":
+		style = st.warn
+	case "CRIT":
+		style = st.crit
+	}
+	return st.help.Render(stamp) + " " + st.focused.Render("["+e.Username+"]") + " " + style.Render(text)
+}
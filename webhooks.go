@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook event types. Each stream event maps to one of them.
+const (
+	WebhookEventCreated      = "entry.created"
+	WebhookEventResolved     = "entry.resolved"
+	WebhookEventAcknowledged = "entry.acknowledged"
+	WebhookEventEscalated    = "entry.escalated"
+)
+
+var webhookEvents = []string{
+	WebhookEventCreated,
+	WebhookEventResolved,
+	WebhookEventAcknowledged,
+	WebhookEventEscalated,
+}
+
+// Webhook delivery statuses. Deliveries that run out of attempts are dead-lettered.
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusDead      = "dead"
+)
+
+// maxWebhookBackoff caps the delay between delivery attempts.
+const maxWebhookBackoff = 6 * time.Hour
+
+// webhookWorkers is the number of webhooks delivered to at the same time.
+// Deliveries to a single webhook are always sent one after the other.
+const webhookWorkers = 8
+
+// Webhook is a subscription that receives events as signed HTTP POSTs.
+// Empty Events, Tags or Users match everything.
+type Webhook struct {
+	ID        int64    `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Tags      []string `json:"tags"`
+	Users     []string `json:"users"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// WebhookDelivery is a single event queued for, or delivered to, a webhook.
+type WebhookDelivery struct {
+	ID           int64           `json:"id"`
+	WebhookID    int64           `json:"webhook_id"`
+	Event        string          `json:"event"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	NextAttempt  string          `json:"next_attempt,omitempty"`
+	ResponseCode int             `json:"response_code,omitempty"`
+	LastError    string          `json:"last_error,omitempty"`
+	CreatedAt    string          `json:"created_at"`
+	UpdatedAt    string          `json:"updated_at"`
+}
+
+// WebhookPayload is the body posted to webhook subscribers.
+type WebhookPayload struct {
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	Entry     Update `json:"entry"`
+}
+
+// webhookEvent maps a broadcast entry to its webhook event type.
+func webhookEvent(u Update) string {
+	if u.Event == "" {
+		return WebhookEventCreated
+	}
+	return "entry." + u.Event
+}
+
+// matches reports whether the webhook subscribes to the event for this entry.
+func (wh Webhook) matches(event string, u Update) bool {
+	if len(wh.Events) > 0 && !slices.Contains(wh.Events, event) {
+		return false
+	}
+	if len(wh.Users) > 0 && !slices.Contains(wh.Users, u.Username) {
+		return false
+	}
+	if len(wh.Tags) > 0 && !slices.ContainsFunc(u.Tags, func(tag string) bool {
+		return slices.Contains(wh.Tags, tag)
+	}) {
+		return false
+	}
+	return true
+}
+
+// signWebhookPayload returns the X-HAL-Signature value for a payload.
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is the delay before the next attempt after the given number of failures.
+func webhookBackoff(base time.Duration, attempts int) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < maxWebhookBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxWebhookBackoff)
+}
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var (
+		wh     Webhook
+		events string
+		tags   string
+		users  string
+	)
+	if err := row.Scan(&wh.ID, &wh.URL, &events, &tags, &users, &wh.Secret, &wh.CreatedAt); err != nil {
+		return wh, err
+	}
+	wh.Events = splitTagsOrEmpty(events)
+	wh.Tags = splitTagsOrEmpty(tags)
+	wh.Users = splitTagsOrEmpty(users)
+	return wh, nil
+}
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var (
+		d           WebhookDelivery
+		payload     string
+		nextAttempt int64
+	)
+	err := row.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts,
+		&nextAttempt, &d.ResponseCode, &d.LastError, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return d, err
+	}
+	d.Payload = json.RawMessage(payload)
+	if d.Status == DeliveryStatusPending {
+		d.NextAttempt = time.Unix(nextAttempt, 0).Format(time.RFC3339)
+	}
+	return d, nil
+}
+
+// splitTagsOrEmpty is splitTags for JSON output, where an empty list reads better than null.
+func splitTagsOrEmpty(v string) []string {
+	if v == "" {
+		return []string{}
+	}
+	return splitTags(v)
+}
+
+// queueWebhooks hands an entry to runWebhookQueue without blocking. The
+// queue grows as needed while the database is slow, rather than holding up
+// the broadcaster or dropping the entry.
+func (s *Server) queueWebhooks(u Update) {
+	s.webhookQueueMu.Lock()
+	s.webhookQueue = append(s.webhookQueue, u)
+	s.webhookQueueMu.Unlock()
+
+	select {
+	case s.webhookQueued <- struct{}{}:
+	default:
+	}
+}
+
+// runWebhookQueue queues webhook deliveries for the entries handed over by
+// the broadcaster, in the order they were published.
+func (s *Server) runWebhookQueue() {
+	for range s.webhookQueued {
+		s.webhookQueueMu.Lock()
+		list := s.webhookQueue
+		s.webhookQueue = nil
+		s.webhookQueueMu.Unlock()
+
+		for _, u := range list {
+			s.enqueueWebhooks(u)
+		}
+	}
+}
+
+// enqueueWebhooks queues a delivery for every webhook subscribed to the entry's event.
+// It is called for every published entry.
+func (s *Server) enqueueWebhooks(u Update) {
+	rows, err := s.db.Query(SelectWebhooksQuery)
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+		return
+	}
+
+	event := webhookEvent(u)
+	var subscribed []Webhook
+	for rows.Next() {
+		wh, err := scanWebhook(rows)
+		if err != nil {
+			continue
+		}
+		if wh.matches(event, u) {
+			subscribed = append(subscribed, wh)
+		}
+	}
+	rows.Close() // nolint:errcheck
+
+	if len(subscribed) == 0 {
+		return
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(WebhookPayload{
+		Event:     event,
+		Timestamp: now.Format(time.RFC3339),
+		Entry:     u,
+	})
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+		return
+	}
+
+	for _, wh := range subscribed {
+		_, err := s.db.Exec(InsertWebhookDeliveryQuery, wh.ID, event, string(payload),
+			now.Unix(), now.Format(time.RFC3339), now.Format(time.RFC3339))
+		if err != nil {
+			log.Printf("webhooks: webhook %d: %v", wh.ID, err)
+		}
+	}
+	s.wakeWebhooks()
+}
+
+// wakeWebhooks nudges the delivery worker without blocking.
+func (s *Server) wakeWebhooks() {
+	select {
+	case s.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// runWebhookDeliveries sends due deliveries whenever new ones are queued,
+// and at least once a second so retries go out on time.
+func (s *Server) runWebhookDeliveries() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.webhookWake:
+		}
+		if err := s.deliverDueWebhooks(time.Now()); err != nil {
+			log.Printf("webhooks: %v", err)
+		}
+	}
+}
+
+// deliverDueWebhooks starts a worker for every webhook with due deliveries,
+// unless one is still busy with it or all workers are taken. Those webhooks
+// are picked up again on a later round.
+func (s *Server) deliverDueWebhooks(now time.Time) error {
+	rows, err := s.db.Query(SelectDueWebhookDeliveriesQuery, now.Unix())
+	if err != nil {
+		return err
+	}
+
+	due := map[int64][]WebhookDelivery{}
+	var order []int64
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			continue
+		}
+		if _, ok := due[d.WebhookID]; !ok {
+			order = append(order, d.WebhookID)
+		}
+		due[d.WebhookID] = append(due[d.WebhookID], d)
+	}
+	rows.Close() // nolint:errcheck
+
+	for _, id := range order {
+		if !s.claimWebhook(id) {
+			continue
+		}
+		select {
+		case s.webhookSlots <- struct{}{}:
+		default:
+			s.releaseWebhook(id)
+			return nil
+		}
+
+		go func(id int64, deliveries []WebhookDelivery) {
+			defer func() { <-s.webhookSlots }()
+			defer s.releaseWebhook(id)
+
+			// After a failure the endpoint is likely down, so the rest
+			// wait for their next attempt instead of timing out in turn.
+			for _, d := range deliveries {
+				if !s.deliverWebhook(d) {
+					return
+				}
+			}
+			// The webhook may have more due deliveries than one round takes.
+			s.wakeWebhooks()
+		}(id, due[id])
+	}
+	return nil
+}
+
+// claimWebhook marks a webhook as being delivered to, reporting false if a
+// worker already has it.
+func (s *Server) claimWebhook(id int64) bool {
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+
+	if s.webhookBusy[id] {
+		return false
+	}
+	s.webhookBusy[id] = true
+	return true
+}
+
+func (s *Server) releaseWebhook(id int64) {
+	s.webhookMu.Lock()
+	delete(s.webhookBusy, id)
+	s.webhookMu.Unlock()
+}
+
+// deliverWebhook makes one delivery attempt and records the outcome,
+// scheduling a retry or dead-lettering the delivery on failure. It reports
+// whether the delivery succeeded.
+func (s *Server) deliverWebhook(d WebhookDelivery) bool {
+	wh, err := scanWebhook(s.db.QueryRow(SelectWebhookQuery, d.WebhookID))
+	if err != nil {
+		// The subscription was deleted after the delivery was queued.
+		return false
+	}
+
+	code, err := s.postWebhook(wh, d)
+
+	now := time.Now()
+	d.Attempts++
+	d.ResponseCode = code
+	d.LastError = ""
+	next := now
+
+	switch {
+	case err == nil:
+		d.Status = DeliveryStatusDelivered
+	case d.Attempts >= s.cfg.WebhookMaxAttempts:
+		d.Status = DeliveryStatusDead
+		d.LastError = err.Error()
+	default:
+		d.Status = DeliveryStatusPending
+		d.LastError = err.Error()
+		next = now.Add(webhookBackoff(s.cfg.WebhookBackoff, d.Attempts))
+	}
+
+	_, err = s.db.Exec(UpdateWebhookDeliveryQuery, d.Status, d.Attempts, next.Unix(),
+		d.ResponseCode, d.LastError, now.Format(time.RFC3339), d.ID)
+	if err != nil {
+		log.Printf("webhooks: delivery %d: %v", d.ID, err)
+	}
+	return d.Status == DeliveryStatusDelivered
+}
+
+// postWebhook sends a signed delivery and returns the response status code.
+func (s *Server) postWebhook(wh Webhook, d WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "HAL-9000-Webhooks")
+	req.Header.Set("X-HAL-Event", d.Event)
+	req.Header.Set("X-HAL-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-HAL-Signature", signWebhookPayload(wh.Secret, d.Payload))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()        // nolint:errcheck
+	io.Copy(io.Discard, resp.Body) // nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var in Webhook
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	u, err := url.Parse(in.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "valid http(s) url required", http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range in.Events {
+		if !slices.Contains(webhookEvents, event) {
+			http.Error(w, fmt.Sprintf("unknown event %q", event), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tags := []string{}
+	for _, tag := range in.Tags {
+		if tag = s.canonicalTag(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	users := []string{}
+	for _, username := range in.Users {
+		if username = strings.ToUpper(strings.TrimSpace(username)); username != "" {
+			users = append(users, username)
+		}
+	}
+
+	wh := Webhook{
+		URL:       in.URL,
+		Events:    in.Events,
+		Tags:      tags,
+		Users:     users,
+		Secret:    in.Secret,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	if wh.Events == nil {
+		wh.Events = []string{}
+	}
+	if wh.Secret == "" {
+		wh.Secret = generateToken()
+	}
+
+	res, err := s.db.Exec(InsertWebhookQuery, wh.URL, strings.Join(wh.Events, ","),
+		strings.Join(wh.Tags, ","), strings.Join(wh.Users, ","), wh.Secret, wh.CreatedAt)
+	if err != nil {
+		http.Error(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+	wh.ID, _ = res.LastInsertId()
+
+	// The secret is only returned when the webhook is created.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(wh) // nolint:errcheck
+}
+
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	rows, err := s.db.Query(SelectWebhooksQuery)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close() // nolint:errcheck
+
+	list := []Webhook{}
+	for rows.Next() {
+		wh, err := scanWebhook(rows)
+		if err != nil {
+			continue
+		}
+		wh.Secret = ""
+		list = append(list, wh)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list) // nolint:errcheck
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.db.Exec(DeleteWebhookQuery, id)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	s.db.Exec(DeleteWebhookDeliveriesQuery, id) // nolint:errcheck
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleWebhookDeliveries is the delivery log of a single webhook, newest first.
+func (s *Server) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := scanWebhook(s.db.QueryRow(SelectWebhookQuery, id)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeWebhookDeliveries(w, SelectWebhookDeliveriesQuery, id)
+}
+
+// handleDeadLetters lists deliveries that ran out of attempts, across all webhooks.
+func (s *Server) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	s.writeWebhookDeliveries(w, SelectDeadWebhookDeliveriesQuery)
+}
+
+func (s *Server) writeWebhookDeliveries(w http.ResponseWriter, query string, args ...any) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close() // nolint:errcheck
+
+	list := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			continue
+		}
+		list = append(list, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list) // nolint:errcheck
+}
+
+// handleRetryDelivery puts a dead-lettered delivery back in the queue.
+func (s *Server) handleRetryDelivery(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	res, err := s.db.Exec(RetryWebhookDeliveryQuery, now.Unix(), now.Format(time.RFC3339), id)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "dead-lettered delivery not found", http.StatusNotFound)
+		return
+	}
+	s.wakeWebhooks()
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDueWebhookDeliveriesWaitForRetries(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	now := time.Now()
+	ts := now.Format(time.RFC3339)
+	for _, url := range []string{"http://failing.example", "http://working.example"} {
+		if _, err := s.db.Exec(InsertWebhookQuery, url, "", "", "", "secret", ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Webhook 1 gets deliveries 1, 3 and 5 and webhook 2 gets 2, 4 and 6.
+	for range 3 {
+		for webhookID := range int64(2) {
+			_, err := s.db.Exec(InsertWebhookDeliveryQuery, webhookID+1, "created", "{}", now.Unix(), ts, ts)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	due := func() []int64 {
+		t.Helper()
+		rows, err := s.db.Query(SelectDueWebhookDeliveriesQuery, now.Unix())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close() // nolint:errcheck
+
+		var ids []int64
+		for rows.Next() {
+			d, err := scanWebhookDelivery(rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, d.ID)
+		}
+		return ids
+	}
+
+	// Delivery 3 failed and waits for its retry, which holds back delivery 5.
+	_, err := s.db.Exec(UpdateWebhookDeliveryQuery, DeliveryStatusPending, 1, now.Add(time.Minute).Unix(), 500, "boom", ts, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := due(), []int64{1, 2, 4, 6}; !slices.Equal(got, want) {
+		t.Errorf("due deliveries %v, want %v", got, want)
+	}
+}